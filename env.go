@@ -0,0 +1,116 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"os"
+	"strings"
+)
+
+// SetEnvPrefix enables environment variable binding for all optional
+// arguments, the variable name is the prefix followed by the token in upper
+// case with "-" replaced by "_", e.g. "REGION_SQL_CONNECTION".  The env tag
+// of an argument takes precedence.  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetEnvPrefix(prefix string) {
+	this.envPrefix = prefix
+	for _, subparser := range this.subParsers() {
+		subparser.SetEnvPrefix(prefix)
+	}
+}
+
+// SetEnvSeparator sets the separator of array and map values in environment
+// variables, the default is ",".  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetEnvSeparator(sep string) {
+	this.envSeparator = sep
+	for _, subparser := range this.subParsers() {
+		subparser.SetEnvSeparator(sep)
+	}
+}
+
+func tokenToEnvName(token string) string {
+	return strings.ToUpper(strings.Replace(token, "-", "_", -1))
+}
+
+// EnvName returns the name of the environment variable bound to the
+// argument, or empty if there is none
+func (this *SingleArgument) EnvName() string {
+	if len(this.envName) > 0 {
+		return this.envName
+	}
+	if this.positional || this.parser == nil || len(this.parser.envPrefix) == 0 {
+		return ""
+	}
+	return this.parser.envPrefix + tokenToEnvName(this.Token())
+}
+
+func argEnvName(arg Argument) string {
+	if envArg, ok := arg.(interface {
+		EnvName() string
+	}); ok && !arg.IsPositional() {
+		return envArg.EnvName()
+	}
+	return ""
+}
+
+// parseEnv sets optional arguments not yet set from their non-empty
+// environment variables
+func (this *ArgumentParser) parseEnv() []error {
+	errs := make([]error, 0)
+	err := this.interpolateSource(func() error {
+		this.parseEnvValues(&errs)
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func (this *ArgumentParser) parseEnvValues(errs *[]error) {
+	for _, arg := range this.optArgs {
+		name := argEnvName(arg)
+		if len(name) == 0 {
+			continue
+		}
+		value := os.Getenv(name)
+		if len(value) == 0 || !this.overrideArgument(arg) {
+			continue
+		}
+		if err := this.setEnvValue(arg, value); err != nil {
+			*errs = append(*errs, err)
+		} else {
+			this.setSource(arg, ValueSource{Kind: SourceEnv, Name: name})
+		}
+	}
+}
+
+func (this *ArgumentParser) setEnvValue(arg Argument, value string) error {
+	if !arg.NeedData() {
+		value, err := this.interpolate(arg, value, nil)
+		if err != nil {
+			return err
+		}
+		return setBoolValue(arg, value, false)
+	}
+	if !arg.IsMulti() {
+		return this.setConfigValue(arg, value)
+	}
+	for _, v := range strings.Split(value, this.envSeparator) {
+		if err := this.setConfigValue(arg, strings.TrimSpace(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
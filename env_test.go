@@ -0,0 +1,142 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func setEnvs(t *testing.T, envs map[string]string) func() {
+	for k, v := range envs {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatalf("setenv %s: %v", k, err)
+		}
+	}
+	return func() {
+		for k := range envs {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestEnvTag(t *testing.T) {
+	type opts struct {
+		AuthURL   string            `env:"TEST_OS_AUTH_URL"`
+		Debug     bool              `env:"TEST_DEBUG"`
+		Insecure  *bool             `env:"TEST_INSECURE"`
+		Networks  []string          `env:"TEST_NETWORKS"`
+		Labels    map[string]string `env:"TEST_LABELS"`
+		Port      int               `env:"TEST_PORT" default:"80"`
+		Region    string
+		Timeout   int `env:"TEST_TIMEOUT" required:"true"`
+		Untouched string
+	}
+	defer setEnvs(t, map[string]string{
+		"TEST_OS_AUTH_URL": "http://127.0.0.1:5000/v3",
+		"TEST_DEBUG":       "true",
+		"TEST_INSECURE":    "false",
+		"TEST_NETWORKS":    "net1, net2",
+		"TEST_LABELS":      "k1=v1,k2=v2",
+		"TEST_PORT":        "8080",
+		"TEST_TIMEOUT":     "30",
+		"REGION":           "should-not-be-used",
+	})()
+
+	t.Run("env", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		insecure := false
+		want := opts{
+			AuthURL:  "http://127.0.0.1:5000/v3",
+			Debug:    true,
+			Insecure: &insecure,
+			Networks: []string{"net1", "net2"},
+			Labels:   map[string]string{"k1": "v1", "k2": "v2"},
+			Port:     8080,
+			Timeout:  30,
+		}
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+	})
+	t.Run("command line wins", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--port", "22", "--networks", "net3"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Port != 22 || !reflect.DeepEqual(s.Networks, []string{"net3"}) {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("env wins over config file", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs2([]string{}, false, false); err != nil {
+			t.Fatalf("ParseArgs2 failed: %s", err)
+		}
+		r := bytes.NewBufferString("port = 9090\nregion = 'region0'\n")
+		if err := p.parseReader(r, ""); err != nil {
+			t.Fatalf("parseReader: %v", err)
+		}
+		p.SetDefault()
+		if s.Port != 8080 || s.Region != "region0" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("invalid value", func(t *testing.T) {
+		defer setEnvs(t, map[string]string{"TEST_PORT": "abc"})()
+		p := mustNewParser(t, &opts{})
+		err := p.ParseArgs([]string{}, false)
+		if _, ok := err.(*InvalidValueError); !ok {
+			t.Errorf("want InvalidValueError, got %v", err)
+		}
+	})
+}
+
+func TestEnvPrefix(t *testing.T) {
+	type opts struct {
+		SqlConnection string
+		AuthURL       string `env:"TEST_AUTH_URL"`
+		DNSResolvers  []string
+		M             struct {
+			NonPos string
+		}
+	}
+	defer setEnvs(t, map[string]string{
+		"REGION_SQL_CONNECTION": "mysql://localhost",
+		"TEST_AUTH_URL":         "http://127.0.0.1:5000/v3",
+		"REGION_AUTH_URL":       "http://should-not-be-used",
+		"REGION_DNS_RESOLVERS":  "8.8.8.8;1.1.1.1",
+		"REGION_M_NON_POS":      "m",
+	})()
+	s := &opts{}
+	p := mustNewParser(t, s)
+	p.SetEnvPrefix("REGION_")
+	p.SetEnvSeparator(";")
+	if err := p.ParseArgs([]string{}, false); err != nil {
+		t.Fatalf("ParseArgs failed: %s", err)
+	}
+	if s.SqlConnection != "mysql://localhost" || s.AuthURL != "http://127.0.0.1:5000/v3" ||
+		!reflect.DeepEqual(s.DNSResolvers, []string{"8.8.8.8", "1.1.1.1"}) || s.M.NonPos != "m" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+}
@@ -15,13 +15,580 @@
 package structarg
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
+	"strings"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// Error kinds, all error types below match the corresponding kind with
+// errors.Is
+const (
+	ErrUnknownArgument     = errors.Error("unknown argument")
+	ErrAmbiguousArgument   = errors.Error("ambiguous argument")
+	ErrMissingValue        = errors.Error("missing value")
+	ErrInvalidChoice       = errors.Error("invalid choice")
+	ErrInvalidValue        = errors.Error("invalid value")
+	ErrArgumentCount       = errors.Error("argument count out of range")
+	ErrRequiredArgument    = errors.Error("required argument not set")
+	ErrNotEnoughArguments  = errors.Error("not enough arguments")
+	ErrUnknownSubcommand   = errors.Error("unknown subcommand")
+	ErrUnknownConfigKey    = errors.Error("unknown config key")
+	ErrIncludeCycle        = errors.Error("config include cycle")
+	ErrUnresolvedReference = errors.Error("unresolved reference")
+	ErrReferenceCycle      = errors.Error("reference cycle")
 )
 
+// ErrorPosition is the index of the offending element in the args passed to
+// ParseArgs2, or -1 if the error does not come from the command line
+type ErrorPosition struct {
+	Position int
+}
+
+func noPosition() ErrorPosition {
+	return ErrorPosition{Position: -1}
+}
+
+func (p *ErrorPosition) position() int {
+	return p.Position
+}
+
+func (p *ErrorPosition) setPosition(pos int) {
+	p.Position = pos
+}
+
+func (p *ErrorPosition) shiftPosition(offset int) {
+	if p.Position >= 0 {
+		p.Position += offset
+	}
+}
+
+type positionedError interface {
+	position() int
+	setPosition(pos int)
+	shiftPosition(offset int)
+}
+
+func withPosition(err error, pos int) error {
+	if perr, ok := err.(positionedError); ok {
+		perr.setPosition(pos)
+	}
+	return err
+}
+
+func shiftPosition(err error, offset int) error {
+	if verrs, ok := err.(ValidationErrors); ok {
+		for _, e := range verrs {
+			shiftPosition(e, offset)
+		}
+	} else if perr, ok := err.(positionedError); ok {
+		perr.shiftPosition(offset)
+	}
+	return err
+}
+
 type NotEnoughArgumentsError struct {
-	argument Argument
+	Argument Argument
 }
 
 func (e *NotEnoughArgumentsError) Error() string {
-	return fmt.Sprintf("Not enough arguments, missing %s", e.argument)
+	return fmt.Sprintf("Not enough arguments, missing %s", e.Argument)
+}
+
+func (e *NotEnoughArgumentsError) Is(target error) bool {
+	return target == ErrNotEnoughArguments
+}
+
+type UnknownArgumentError struct {
+	ErrorPosition
+	Input       string
+	Positional  bool
+	Suggestions []string
+}
+
+func (e *UnknownArgumentError) Error() string {
+	if e.Positional {
+		return fmt.Sprintf("Unknown positional argument %s", e.Input)
+	}
+	msg := fmt.Sprintf("Unknown optional argument %s", e.Input)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", ChoicesString(e.Suggestions))
+	}
+	return msg
+}
+
+func (e *UnknownArgumentError) Is(target error) bool {
+	return target == ErrUnknownArgument
+}
+
+type AmbiguousArgumentError struct {
+	ErrorPosition
+	Token      string
+	Candidates []string
+}
+
+func (e *AmbiguousArgumentError) Error() string {
+	return fmt.Sprintf("Ambiguous optional argument --%s, could match %s", e.Token, ChoicesString(e.Candidates))
+}
+
+func (e *AmbiguousArgumentError) Is(target error) bool {
+	return target == ErrAmbiguousArgument
+}
+
+type MissingValueError struct {
+	ErrorPosition
+	Argument Argument
+	Input    string
+}
+
+func (e *MissingValueError) Error() string {
+	return fmt.Sprintf("Missing arguments for %s", e.Input)
+}
+
+func (e *MissingValueError) Is(target error) bool {
+	return target == ErrMissingValue
+}
+
+type InvalidChoiceError struct {
+	ErrorPosition
+	Argument    Argument
+	Value       string
+	Choices     []string
+	Suggestions []string
+}
+
+func (e *InvalidChoiceError) Error() string {
+	msg := fmt.Sprintf("Unknown argument '%s' for %s", redactString(e.Argument, e.Value), e.Argument.Token())
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", quotedChoicesString(e.Suggestions))
+	} else if len(e.Choices) > 0 {
+		msg += fmt.Sprintf(", accepts %s", quotedChoicesString(e.Choices))
+	}
+	return msg
+}
+
+func (e *InvalidChoiceError) Is(target error) bool {
+	return target == ErrInvalidChoice
+}
+
+// InvalidValueError is returned when a value cannot be converted to the type
+// of the argument.  The message of a secret argument does not contain the
+// value.
+type InvalidValueError struct {
+	ErrorPosition
+	Argument Argument
+	Value    string
+	Err      error
+}
+
+func (e *InvalidValueError) Error() string {
+	if argIsSecret(e.Argument) {
+		// the conversion error may contain the value
+		return fmt.Sprintf("Invalid value %q for %s", RedactedValue, e.Argument.Token())
+	}
+	return fmt.Sprintf("Invalid value %q for %s: %v", e.Value, e.Argument.Token(), e.Err)
+}
+
+func (e *InvalidValueError) Is(target error) bool {
+	return target == ErrInvalidValue
+}
+
+func (e *InvalidValueError) Unwrap() error {
+	return e.Err
+}
+
+// ArgumentCountError is returned when the number of values of a multi
+// argument is out of range, a negative Max means no upper limit
+type ArgumentCountError struct {
+	Argument Argument
+	Count    int64
+	Min      int64
+	Max      int64
+}
+
+func (e *ArgumentCountError) Error() string {
+	if e.Count < e.Min {
+		return fmt.Sprintf("%s error: Argument count requires at least %d", e.Argument.Token(), e.Min)
+	}
+	return fmt.Sprintf("%s error: Argument count requires at most %d", e.Argument.Token(), e.Max)
+}
+
+func (e *ArgumentCountError) Is(target error) bool {
+	return target == ErrArgumentCount
+}
+
+type RequiredArgumentError struct {
+	Argument Argument
+}
+
+func (e *RequiredArgumentError) Error() string {
+	return fmt.Sprintf("%s error: Non-optional argument %s not set", e.Argument.Token(), e.Argument.Token())
+}
+
+func (e *RequiredArgumentError) Is(target error) bool {
+	return target == ErrRequiredArgument
+}
+
+// ArgumentError wraps other failures of validating an argument, e.g. of
+// custom Argument implementations, with the argument
+type ArgumentError struct {
+	Argument Argument
+	Err      error
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("%s error: %s", e.Argument.Token(), e.Err)
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return e.Err
+}
+
+type UnknownSubcommandError struct {
+	ErrorPosition
+	Argument    Argument
+	Command     string
+	Subcommands []string
+	Suggestions []string
+}
+
+func (e *UnknownSubcommandError) Error() string {
+	msg := fmt.Sprintf("Unknown subcommand %s", e.Command)
+	if len(e.Suggestions) > 0 {
+		msg += fmt.Sprintf(", did you mean %s?", quotedChoicesString(e.Suggestions))
+	}
+	return msg
+}
+
+func (e *UnknownSubcommandError) Is(target error) bool {
+	return target == ErrUnknownSubcommand
+}
+
+// ConfigError is an error of a config source at a position
+type ConfigError struct {
+	File string
+	// Line and Column start from 1, 0 if unknown
+	Line   int
+	Column int
+	// Key is the config key of the error, empty if unknown
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	var buf bytes.Buffer
+	buf.WriteString(configPosition(e.File, e.Line))
+	if e.Line > 0 && e.Column > 0 {
+		fmt.Fprintf(&buf, ":%d", e.Column)
+	}
+	if len(e.Key) > 0 {
+		fmt.Fprintf(&buf, ": %s", e.Key)
+	}
+	fmt.Fprintf(&buf, ": %v", e.Err)
+	return buf.String()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configPosition returns the position in a config source, e.g.
+// "/etc/region.conf:12"
+func configPosition(file string, line int) string {
+	if line > 0 {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return file
+}
+
+// UnknownConfigKey is a key of a config source matching no argument
+type UnknownConfigKey struct {
+	Key  string
+	File string
+	// Line is the line number in the file, 0 if unknown
+	Line int
+}
+
+func (k UnknownConfigKey) String() string {
+	if len(k.File) > 0 {
+		return fmt.Sprintf("%s (%s)", k.Key, configPosition(k.File, k.Line))
+	}
+	return k.Key
+}
+
+// UnknownConfigKeysError lists the unknown keys of a config source in
+// strict config mode, see SetStrictConfig
+type UnknownConfigKeysError struct {
+	Keys []UnknownConfigKey
+}
+
+func (e *UnknownConfigKeysError) Error() string {
+	keys := make([]string, len(e.Keys))
+	for i := range e.Keys {
+		keys[i] = e.Keys[i].String()
+	}
+	return fmt.Sprintf("Unknown config keys: %s", strings.Join(keys, ", "))
+}
+
+func (e *UnknownConfigKeysError) Is(target error) bool {
+	return target == ErrUnknownConfigKey
+}
+
+func unknownConfigKeyErr(key string) error {
+	return &UnknownConfigKeysError{Keys: []UnknownConfigKey{{Key: key}}}
+}
+
+// unknownKeyCollector collects the unknown keys of a config source in
+// strict config mode, and logs them otherwise
+type unknownKeyCollector struct {
+	strict bool
+	keys   []UnknownConfigKey
+}
+
+// check collects the unknown keys of err, other errors are returned
+func (c *unknownKeyCollector) check(err error, file string, line int) error {
+	ukerr, ok := err.(*UnknownConfigKeysError)
+	if !ok {
+		return err
+	}
+	for _, k := range ukerr.Keys {
+		if len(k.File) == 0 {
+			k.File = file
+			k.Line = line
+		}
+		if c.strict {
+			c.keys = append(c.keys, k)
+		} else {
+			log.Warningf("%s: Cannot find argument %s", configPosition(k.File, k.Line), k.Key)
+		}
+	}
+	return nil
+}
+
+func (c *unknownKeyCollector) err() error {
+	if len(c.keys) > 0 {
+		return &UnknownConfigKeysError{Keys: c.keys}
+	}
+	return nil
+}
+
+// IncludeCycleError is returned when a config file includes itself directly
+// or indirectly, Files is the chain of includes ending with the repeated
+// file
+type IncludeCycleError struct {
+	Files []string
+}
+
+func (e *IncludeCycleError) Error() string {
+	return fmt.Sprintf("Include cycle %s", strings.Join(e.Files, " -> "))
+}
+
+func (e *IncludeCycleError) Is(target error) bool {
+	return target == ErrIncludeCycle
+}
+
+// ReferenceError is returned when a reference in a config value, e.g.
+// "${AUTH_HOST}", cannot be expanded
+type ReferenceError struct {
+	Reference string
+	// Cycle is the chain of option references ending with the repeated
+	// option, empty if the reference is unresolved
+	Cycle []string
+}
+
+func (e *ReferenceError) Error() string {
+	if len(e.Cycle) > 0 {
+		return fmt.Sprintf("Reference cycle ${%s}", strings.Join(e.Cycle, "} -> ${"))
+	}
+	return fmt.Sprintf("Unresolved reference ${%s}", e.Reference)
+}
+
+func (e *ReferenceError) Is(target error) bool {
+	if len(e.Cycle) > 0 {
+		return target == ErrReferenceCycle
+	}
+	return target == ErrUnresolvedReference
+}
+
+// ValidationErrors collects all failures found in one pass of parsing or
+// validation
+type ValidationErrors []error
+
+func (e ValidationErrors) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d errors occurred:", len(e))
+	for _, err := range e {
+		buf.WriteString("\n  * ")
+		buf.WriteString(err.Error())
+	}
+	return buf.String()
+}
+
+// Errors returns the collected errors
+func (e ValidationErrors) Errors() []error {
+	return e
+}
+
+// Is reports whether any of the collected errors matches the target, so
+// that errors.Is inspects each collected error
+func (e ValidationErrors) Is(target error) bool {
+	for _, err := range e {
+		if errorIs(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first of the collected errors that matches the target, so
+// that errors.As inspects each collected error
+func (e ValidationErrors) As(target interface{}) bool {
+	for _, err := range e {
+		if errorAs(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorIs is errors.Is of newer Go versions
+func errorIs(err, target error) bool {
+	if err == nil || target == nil {
+		return err == target
+	}
+	isComparable := reflect.TypeOf(target).Comparable()
+	for err != nil {
+		if isComparable && err == target {
+			return true
+		}
+		if x, ok := err.(interface {
+			Is(error) bool
+		}); ok && x.Is(target) {
+			return true
+		}
+		if x, ok := err.(interface {
+			Unwrap() []error
+		}); ok {
+			for _, e := range x.Unwrap() {
+				if errorIs(e, target) {
+					return true
+				}
+			}
+			return false
+		}
+		err = unwrapError(err)
+	}
+	return false
+}
+
+// errorAs is errors.As of newer Go versions, the target must be a non-nil
+// pointer to an interface or a type implementing error
+func errorAs(err error, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if target == nil || val.Kind() != reflect.Ptr || val.IsNil() {
+		panic("structarg: target must be a non-nil pointer")
+	}
+	targetType := val.Type().Elem()
+	for err != nil {
+		if reflect.TypeOf(err).AssignableTo(targetType) {
+			val.Elem().Set(reflect.ValueOf(err))
+			return true
+		}
+		if x, ok := err.(interface {
+			As(interface{}) bool
+		}); ok && x.As(target) {
+			return true
+		}
+		if x, ok := err.(interface {
+			Unwrap() []error
+		}); ok {
+			for _, e := range x.Unwrap() {
+				if errorAs(e, target) {
+					return true
+				}
+			}
+			return false
+		}
+		err = unwrapError(err)
+	}
+	return false
+}
+
+func unwrapError(err error) error {
+	if x, ok := err.(interface {
+		Unwrap() error
+	}); ok {
+		return x.Unwrap()
+	}
+	return nil
+}
+
+// joinErrors returns nil for no error, the error itself for a single error,
+// otherwise a flattened ValidationErrors
+func joinErrors(errs []error) error {
+	flat := make(ValidationErrors, 0, len(errs))
+	for _, err := range errs {
+		if verrs, ok := err.(ValidationErrors); ok {
+			flat = append(flat, verrs...)
+		} else if err != nil {
+			flat = append(flat, err)
+		}
+	}
+	switch len(flat) {
+	case 0:
+		return nil
+	case 1:
+		return flat[0]
+	default:
+		return flat
+	}
+}
+
+// errorCollector collects conversion and choices errors of arguments so
+// that parsing continues, other errors stop parsing
+type errorCollector struct {
+	failFast bool
+	errs     []error
+	failed   map[Argument]bool
+}
+
+func newErrorCollector(failFast bool) *errorCollector {
+	return &errorCollector{
+		failFast: failFast,
+		errs:     make([]error, 0),
+		failed:   make(map[Argument]bool),
+	}
+}
+
+// collect returns the error if parsing should stop, otherwise nil
+func (c *errorCollector) collect(e error, pos int) error {
+	if e == nil {
+		return nil
+	}
+	e = withPosition(e, pos)
+	if c.failFast {
+		return e
+	}
+	switch verr := e.(type) {
+	case *InvalidValueError:
+		c.failed[verr.Argument] = true
+	case *InvalidChoiceError:
+		c.failed[verr.Argument] = true
+	default:
+		return e
+	}
+	c.errs = append(c.errs, e)
+	return nil
+}
+
+func (c *errorCollector) collectAll(errs []error) error {
+	for _, e := range errs {
+		if err := c.collect(e, -1); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,49 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.13
+// +build go1.13
+
+package structarg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidationErrorsStdErrors(t *testing.T) {
+	type opts struct {
+		Port     int    `required:"true"`
+		Protocol string `choices:"tcp|udp"`
+	}
+	p := mustNewParser(t, &opts{})
+	err := p.ParseArgs([]string{"--port", "abc", "--protocol", "icmp"}, false)
+	if _, ok := err.(ValidationErrors); !ok {
+		t.Fatalf("want ValidationErrors, got %v", err)
+	}
+	if !errors.Is(err, ErrInvalidChoice) || !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("errors.Is should find the collected errors in %v", err)
+	}
+	if errors.Is(err, ErrUnknownArgument) {
+		t.Errorf("errors.Is should not find ErrUnknownArgument in %v", err)
+	}
+	var choiceErr *InvalidChoiceError
+	if !errors.As(err, &choiceErr) || choiceErr.Value != "icmp" {
+		t.Errorf("errors.As should find the InvalidChoiceError in %v", err)
+	}
+	var cerr *ConfigError
+	if errors.As(err, &cerr) {
+		t.Errorf("errors.As should not find a ConfigError in %v", err)
+	}
+}
@@ -0,0 +1,461 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type errorsTestOptions struct {
+	Port     int      `short-token:"p"`
+	Protocol string   `choices:"tcp|udp"`
+	Networks []string `nargs:"+"`
+	Required string   `required:"true"`
+
+	SUBCOMMAND string `subcommand:"true"`
+}
+
+type errorsTestSubOptions struct {
+	Name string
+	ID   string
+}
+
+func newErrorsTestParser(t *testing.T) *ArgumentParser {
+	p := mustNewParser(t, &errorsTestOptions{})
+	_, err := p.GetSubcommand().AddSubParser(&errorsTestSubOptions{}, "show", "Show", func(opts *errorsTestSubOptions) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	return p
+}
+
+func TestTypedErrors(t *testing.T) {
+	base := []string{"--required", "r", "--networks", "n1"}
+	cases := []struct {
+		name     string
+		args     []string
+		kind     error
+		position int
+	}{
+		{
+			name:     "unknown option",
+			args:     append([]string{"--unknown"}, base...),
+			kind:     ErrUnknownArgument,
+			position: 0,
+		},
+		{
+			name:     "unknown positional",
+			args:     append(append([]string{}, base...), "show", "id", "extra"),
+			kind:     ErrUnknownArgument,
+			position: 6,
+		},
+		{
+			name:     "missing value",
+			args:     append(append([]string{}, base...), "--port"),
+			kind:     ErrMissingValue,
+			position: 4,
+		},
+		{
+			name:     "invalid choice",
+			args:     append([]string{"--protocol", "icmp"}, base...),
+			kind:     ErrInvalidChoice,
+			position: 1,
+		},
+		{
+			name:     "invalid value",
+			args:     append([]string{"-pabc"}, base...),
+			kind:     ErrInvalidValue,
+			position: 0,
+		},
+		{
+			name:     "required",
+			args:     []string{"--networks", "n1", "show", "id"},
+			kind:     ErrRequiredArgument,
+			position: -1,
+		},
+		{
+			name:     "count",
+			args:     []string{"--required", "r", "show", "id"},
+			kind:     ErrArgumentCount,
+			position: -1,
+		},
+		{
+			name:     "unknown subcommand",
+			args:     append(append([]string{}, base...), "shwo"),
+			kind:     ErrUnknownSubcommand,
+			position: 4,
+		},
+		{
+			name:     "not enough arguments",
+			args:     append(append([]string{}, base...), "show"),
+			kind:     ErrNotEnoughArguments,
+			position: -1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := newErrorsTestParser(t)
+			err := p.ParseArgs(c.args, false)
+			if err == nil {
+				t.Fatalf("expecting error")
+			}
+			if !errorIs(err, c.kind) {
+				t.Errorf("want %q, got %v", c.kind, err)
+			}
+			var perr positionedError
+			if errorAs(err, &perr) {
+				if pos := perr.position(); pos != c.position {
+					t.Errorf("position: want %d, got %d", c.position, pos)
+				}
+			} else if c.position >= 0 {
+				t.Errorf("expecting position %d, got none", c.position)
+			}
+		})
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	type serverListOptions struct{}
+	type serverOptions struct {
+		SUBCOMMAND string `subcommand:"true"`
+	}
+	s := &struct {
+		Region     string
+		AuthURL    string `alias:"auth-uri"`
+		Debug      bool   `negative:"no-debug"`
+		Verbose    bool   `short-token:"v"`
+		SUBCOMMAND string `subcommand:"true"`
+	}{}
+	p := mustNewParser(t, s)
+	subcmd := p.GetSubcommand()
+	callback := func(opts interface{}) error { return nil }
+	subcmd.AddSubParser(&struct{}{}, "network-list", "List networks", callback)
+	serverp, err := subcmd.AddSubParser(&serverOptions{}, "server", "Servers", callback)
+	if err != nil {
+		t.Fatalf("AddSubParser: %v", err)
+	}
+	serverp.GetSubcommand().AddSubParser(&serverListOptions{}, "list", "List servers", callback)
+	serverp.GetSubcommand().AddSubParser(&serverListOptions{}, "create", "Create server", callback)
+
+	t.Run("options", func(t *testing.T) {
+		cases := []struct {
+			arg  string
+			want []string
+		}{
+			{"--regoin", []string{"--region"}},
+			{"--auth-rul=x", []string{"--auth-url", "--auth-uri"}},
+			{"--no-debgu", []string{"--no-debug", "--debug"}},
+			{"--zzzzzz", nil},
+		}
+		for _, c := range cases {
+			err := p.ParseArgs([]string{c.arg, "server", "list"}, false)
+			var unknownErr *UnknownArgumentError
+			if !errorAs(err, &unknownErr) {
+				t.Fatalf("%s: want UnknownArgumentError, got %v", c.arg, err)
+			}
+			if !reflect.DeepEqual(unknownErr.Suggestions, c.want) && !(len(c.want) == 0 && len(unknownErr.Suggestions) == 0) {
+				t.Errorf("%s: want %v, got %v", c.arg, c.want, unknownErr.Suggestions)
+			}
+		}
+	})
+	t.Run("subcommands", func(t *testing.T) {
+		cases := []struct {
+			args []string
+			want []string
+		}{
+			{[]string{"netwrok-list"}, []string{"network-list"}},
+			{[]string{"sever", "list"}, []string{"server"}},
+			{[]string{"lsit"}, []string{"server list"}},
+			{[]string{"server", "craete"}, []string{"create"}},
+		}
+		for _, c := range cases {
+			err := p.ParseArgs(c.args, false)
+			var subErr *UnknownSubcommandError
+			if !errorAs(err, &subErr) {
+				t.Fatalf("%v: want UnknownSubcommandError, got %v", c.args, err)
+			}
+			if !reflect.DeepEqual(subErr.Suggestions, c.want) {
+				t.Errorf("%v: want %v, got %v", c.args, c.want, subErr.Suggestions)
+			}
+		}
+	})
+}
+
+func TestValidationErrors(t *testing.T) {
+	type opts struct {
+		Port     int      `required:"true"`
+		User     string   `required:"true"`
+		Project  string   `required:"true"`
+		Protocol string   `choices:"tcp|udp"`
+		Networks []string `nargs:"+"`
+	}
+	t.Run("collect all", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		err := p.ParseArgs([]string{"--port", "abc", "--protocol", "icmp"}, false)
+		verrs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Fatalf("want ValidationErrors, got %v", err)
+		}
+		// required optional arguments come last
+		kinds := []error{ErrInvalidValue, ErrInvalidChoice, ErrArgumentCount, ErrRequiredArgument, ErrRequiredArgument}
+		if len(verrs.Errors()) != len(kinds) {
+			t.Fatalf("want %d errors, got %d: %v", len(kinds), len(verrs.Errors()), err)
+		}
+		for i, kind := range kinds {
+			if !errorIs(verrs.Errors()[i], kind) {
+				t.Errorf("error %d: want %q, got %v", i, kind, verrs.Errors()[i])
+			}
+		}
+		if !verrs.Is(ErrInvalidChoice) {
+			t.Errorf("Is should find ErrInvalidChoice in %v", err)
+		}
+		var valErr *InvalidValueError
+		if !verrs.As(&valErr) || valErr.Argument.Token() != "port" {
+			t.Errorf("As should find the InvalidValueError of port in %v", err)
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		p.ParseArgs([]string{"--port", "80", "--networks", "n1"}, false)
+		err := p.Validate()
+		if verrs, ok := err.(ValidationErrors); !ok || len(verrs) != 2 {
+			t.Errorf("want 2 errors, got %v", err)
+		}
+	})
+	t.Run("single error", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		err := p.ParseArgs([]string{"--port", "80", "--user", "u", "--networks", "n1"}, false)
+		if _, ok := err.(*RequiredArgumentError); !ok {
+			t.Errorf("want RequiredArgumentError, got %v", err)
+		}
+	})
+	t.Run("fail fast", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		p.SetFailFast(true)
+		err := p.ParseArgs([]string{"--port", "abc", "--protocol", "icmp"}, false)
+		if _, ok := err.(*InvalidValueError); !ok {
+			t.Errorf("want InvalidValueError, got %v", err)
+		}
+		err = p.ParseArgs([]string{"--port", "80"}, false)
+		if _, ok := err.(*ArgumentCountError); !ok {
+			t.Errorf("want ArgumentCountError, got %v", err)
+		}
+	})
+}
+
+func TestStrictConfig(t *testing.T) {
+	type opts struct {
+		SqlConnection string
+		Port          int
+		M             struct {
+			NonPos string
+		}
+	}
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tornado := writeTestFile(t, dir, "region.conf", "# region\nsql_conection = 'mysql://localhost'\nport = 8080\nadmin_user = 'admin'\n")
+	yaml := writeTestFile(t, dir, "region.yaml", "sql_connection: mysql://localhost\nm:\n  non_pos: x\n  non_poss: y\n")
+	toml := writeTestFile(t, dir, "region.toml", "port = 8080\n[m]\nnon-pos = 'x'\nnonpos = 'y'\n")
+	badValue := writeTestFile(t, dir, "bad.conf", "port = abc\n")
+
+	cases := []struct {
+		file string
+		want []UnknownConfigKey
+	}{
+		{tornado, []UnknownConfigKey{{"sql-conection", tornado, 2}, {"admin-user", tornado, 4}}},
+		{yaml, []UnknownConfigKey{{"m.non_poss", yaml, 4}}},
+		{toml, []UnknownConfigKey{{"m.nonpos", toml, 4}}},
+	}
+	for _, c := range cases {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		p.SetStrictConfig(true)
+		err := p.ParseFile(c.file)
+		var ukerr *UnknownConfigKeysError
+		if !errorAs(err, &ukerr) || !errorIs(err, ErrUnknownConfigKey) {
+			t.Fatalf("%s: want UnknownConfigKeysError, got %v", c.file, err)
+		}
+		if !reflect.DeepEqual(ukerr.Keys, c.want) {
+			t.Errorf("%s: want %v, got %v", c.file, c.want, ukerr.Keys)
+		}
+		// known keys are still set
+		if s.SqlConnection == "" && s.Port == 0 {
+			t.Errorf("%s: known keys not set: %#v", c.file, s)
+		}
+
+		p = mustNewParser(t, &opts{})
+		if err := p.ParseFile(c.file); err != nil {
+			t.Errorf("%s: unknown keys should be ignored when not strict, got %v", c.file, err)
+		}
+	}
+
+	for _, strict := range []bool{false, true} {
+		p := mustNewParser(t, &opts{})
+		p.SetStrictConfig(strict)
+		err = p.ParseTornadoFile(badValue)
+		var cerr *ConfigError
+		if !errorIs(err, ErrInvalidValue) || !errorAs(err, &cerr) || cerr.Line != 1 || cerr.Key != "port" {
+			t.Errorf("strict %v: want invalid value error of line 1, got %v", strict, err)
+		}
+	}
+
+	// the other lines are still parsed, all invalid values are returned
+	badValues := writeTestFile(t, dir, "bad2.conf", "port = abc\nsql_connection = 'mysql://localhost'\nport = def\n")
+	s := &opts{}
+	p := mustNewParser(t, s)
+	err = p.ParseTornadoFile(badValues)
+	verrs, ok := err.(ValidationErrors)
+	if !ok || len(verrs) != 2 || s.SqlConnection != "mysql://localhost" {
+		t.Errorf("want 2 invalid values and sql_connection set, got %v, %#v", err, s)
+	}
+	p = mustNewParser(t, &opts{})
+	p.SetFailFast(true)
+	if err := p.ParseTornadoFile(badValues); !errorIs(err, ErrInvalidValue) {
+		t.Errorf("fail fast: want the first invalid value, got %v", err)
+	}
+
+	// a tornado file which is also a YAML mapping falls back to the
+	// tornado style unless strict
+	mapping := writeTestFile(t, dir, "mapping.conf", "port: 80=8080\nsql_connection = x: y\n")
+	s = &opts{}
+	p = mustNewParser(t, s)
+	if err := p.ParseFile(mapping); err != nil || s.SqlConnection != "x: y" {
+		t.Errorf("want the tornado style, got %v, %#v", err, s)
+	}
+	p = mustNewParser(t, &opts{})
+	p.SetStrictConfig(true)
+	if err := p.ParseFile(mapping); !errorIs(err, ErrInvalidValue) {
+		t.Errorf("strict: want the invalid value of the YAML mapping, got %v", err)
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	type opts struct {
+		Port     int
+		Networks []string
+		M        struct {
+			Timeout int
+		}
+	}
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		name    string
+		content string
+		line    int
+		column  int
+		key     string
+	}{
+		{"misformatted.conf", "port = 80\nnetworks\n", 2, 1, ""},
+		{"syntax.yaml", "port: 80\nnetworks: [a\nm: b\n", 2, 0, ""},
+		{"value.yaml", "port: 80\nm:\n  timeout: abc\n", 3, 0, "m.timeout"},
+		{"value.yml", "networks: [a]\n\n'port': abc\n", 3, 0, "port"},
+		{"syntax.json", "{\"port\": 80,\n \"networks\": [\"a\" \"b\"]}", 2, 19, ""},
+		{"value.json", "{\"port\": \"abc\"}", 1, 0, "port"},
+		{"syntax.toml", "port = 80\nnetworks = [\"a\"\n\nm = 1\n", 2, 0, ""},
+		{"value.toml", "port = 80\n[m]\ntimeout = 'abc'\n", 3, 0, "m.timeout"},
+	}
+	for _, c := range cases {
+		file := writeTestFile(t, dir, c.name, c.content)
+		p := mustNewParser(t, &opts{})
+		p.SetStrictConfig(true)
+		err := p.ParseFile(file)
+		var cerr *ConfigError
+		if !errorAs(err, &cerr) {
+			t.Errorf("%s: want ConfigError, got %v", c.name, err)
+			continue
+		}
+		if cerr.File != file || cerr.Line != c.line || cerr.Column != c.column || cerr.Key != c.key {
+			t.Errorf("%s: want %d:%d %q, got %d:%d %q: %v", c.name, c.line, c.column, c.key, cerr.Line, cerr.Column, cerr.Key, err)
+		}
+		if !strings.HasPrefix(err.Error(), file+":") {
+			t.Errorf("%s: error should start with the position, got %v", c.name, err)
+		}
+	}
+}
+
+func TestInvalidValueError(t *testing.T) {
+	p := newErrorsTestParser(t)
+	err := p.ParseArgs([]string{"--port", "abc", "--required", "r", "--networks", "n1", "show", "id"}, false)
+	var valErr *InvalidValueError
+	if !errorAs(err, &valErr) {
+		t.Fatalf("want InvalidValueError, got %v", err)
+	}
+	if valErr.Argument.Token() != "port" || valErr.Value != "abc" || valErr.Position != 1 {
+		t.Errorf("wrong error: %#v", valErr)
+	}
+	if unwrapError(err) != valErr.Err {
+		t.Errorf("InvalidValueError should unwrap to the conversion error")
+	}
+
+	var choiceErr *InvalidChoiceError
+	err = p.ParseArgs([]string{"--protocol", "udpx"}, false)
+	if !errorAs(err, &choiceErr) {
+		t.Fatalf("want InvalidChoiceError, got %v", err)
+	}
+	if choiceErr.Value != "udpx" || len(choiceErr.Suggestions) == 0 || choiceErr.Suggestions[0] != "udp" {
+		t.Errorf("wrong error: %#v", choiceErr)
+	}
+}
+
+var errTestZoneUnavailable = errors.New("zone unavailable")
+
+// zoneTestArgument is a custom argument failing validation with its own
+// error
+type zoneTestArgument struct {
+	*SingleArgument
+}
+
+func (arg *zoneTestArgument) Validate() error {
+	return errTestZoneUnavailable
+}
+
+func TestArgumentError(t *testing.T) {
+	p := mustNewParser(t, &struct{}{})
+	zone := ""
+	arg := &zoneTestArgument{&SingleArgument{
+		token:  "zone",
+		value:  reflect.ValueOf(&zone).Elem(),
+		ovalue: reflect.ValueOf(""),
+		parser: p,
+	}}
+	if err := p.AddArgument(arg); err != nil {
+		t.Fatalf("AddArgument: %v", err)
+	}
+	err := p.ParseArgs([]string{"--zone", "z1"}, false)
+	var argErr *ArgumentError
+	if !errorAs(err, &argErr) {
+		t.Fatalf("want ArgumentError, got %#v", err)
+	}
+	if argErr.Argument != arg || !errorIs(err, errTestZoneUnavailable) {
+		t.Errorf("wrong error: %#v", argErr)
+	}
+	if want := "zone error: zone unavailable"; err.Error() != want {
+		t.Errorf("want %q, got %q", want, err.Error())
+	}
+}
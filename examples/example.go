@@ -32,7 +32,7 @@ type Options struct {
 	AuthURL       string   `help:"Keystone auth URL" alias:"auth-uri"`
 	AdminUser     string   `help:"Admin username"`
 	AdminDomain   string   `help:"Admin user domain"`
-	AdminPassword string   `help:"Admin password"`
+	AdminPassword string   `help:"Admin password" secret:"true"`
 	AdminProject  string   `help:"Admin project" default:"system" alias:"admin-tenant-name"`
 	CorsHosts     []string `help:"List of hostname that allow CORS"`
 
@@ -108,7 +108,10 @@ func main() {
 		fmt.Print(parser.HelpString())
 	} else {
 		fmt.Printf("################## Options #################\n")
-		fmt.Printf("%#v\n", options)
+		// the values of secret options, e.g. AdminPassword, are masked
+		for _, src := range parser.Sources() {
+			fmt.Printf("%s = %v (%s)\n", src.Argument.Token(), src.Value, src.Source)
+		}
 		fmt.Printf("############################################\n")
 		subcmd := parser.GetSubcommand()
 		if subcmd == nil {
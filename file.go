@@ -0,0 +1,167 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// FileTokenSuffix is appended to the token of an argument read from a file,
+// e.g. --admin-password-file for --admin-password
+const FileTokenSuffix = "-file"
+
+// sFileArg is the companion of an argument taking its value from a file,
+// e.g. a Docker or Kubernetes secret mounted at /run/secrets/admin_password.
+// The companion is set when the argument is set, so that the argument is
+// set once by the source of the highest precedence.
+type sFileArg struct {
+	target Argument
+	parser *ArgumentParser
+	// the file read, to be read again by Reload
+	path string
+}
+
+func (self *sFileArg) AliasToken() string {
+	return ""
+}
+
+func (self *sFileArg) DoAction(nega bool) error {
+	return nil
+}
+
+func (self *sFileArg) HelpString(indent string) string {
+	return fmt.Sprintf("%sRead --%s from the file", indent, self.target.Token())
+}
+
+func (self *sFileArg) NeedData() bool {
+	return true
+}
+
+func (self *sFileArg) Token() string {
+	return self.target.Token() + FileTokenSuffix
+}
+
+func (self *sFileArg) ShortToken() string {
+	return ""
+}
+
+func (self *sFileArg) NegativeToken() string {
+	return ""
+}
+
+func (self *sFileArg) MetaVar() string {
+	return "FILE"
+}
+
+func (self *sFileArg) IsPositional() bool {
+	return false
+}
+
+func (self *sFileArg) IsRequired() bool {
+	return false
+}
+
+func (self *sFileArg) IsMulti() bool {
+	return false
+}
+
+func (self *sFileArg) IsSubcommand() bool {
+	return false
+}
+
+func (self *sFileArg) String() string {
+	return fmt.Sprintf("[--%s %s]", self.Token(), self.MetaVar())
+}
+
+// SetValue sets the target argument to the content of the file without
+// trailing newlines, each non-empty line is a value of a multi argument.
+// The file is watched by ConfigWatcher, e.g. for a rotated secret.
+func (self *sFileArg) SetValue(val string) error {
+	self.path = val
+	self.parser.addConfigFile(val)
+	content, err := ioutil.ReadFile(val)
+	if err != nil {
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: self, Value: val, Err: err}
+	}
+	str := strings.TrimRight(string(content), "\r\n")
+	if !self.target.IsMulti() {
+		return self.target.SetValue(str)
+	}
+	for _, line := range strings.Split(str, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if len(line) == 0 {
+			continue
+		}
+		if err := self.target.SetValue(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *sFileArg) Reset() {
+	self.path = ""
+	self.target.Reset()
+}
+
+func (self *sFileArg) Validate() error {
+	return nil
+}
+
+func (self *sFileArg) SetDefault() {
+}
+
+func (self *sFileArg) IsSet() bool {
+	return self.target.IsSet()
+}
+
+// EnvName returns the environment variable of the file, the variable of
+// the target argument with the suffix "_FILE", e.g. ADMIN_PASSWORD_FILE
+func (self *sFileArg) EnvName() string {
+	if name := argEnvName(self.target); len(name) > 0 {
+		return name + "_FILE"
+	}
+	return ""
+}
+
+func (this *SingleArgument) readFromFile() bool {
+	return this.fromFile
+}
+
+// addFileArguments adds the companions of the arguments read from files,
+// unless the token of the companion is taken
+func (this *ArgumentParser) addFileArguments() error {
+	// AddArgument reorders the arguments
+	args := make([]Argument, len(this.optArgs))
+	copy(args, this.optArgs)
+	for _, arg := range args {
+		fileArg, ok := arg.(interface {
+			readFromFile() bool
+		})
+		if !ok || !fileArg.readFromFile() || !arg.NeedData() {
+			continue
+		}
+		companion := &sFileArg{target: arg, parser: this}
+		if match, _, _ := this.findOptionalArgument(companion.Token(), true); match != nil {
+			continue
+		}
+		if err := this.AddArgument(companion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fileTestOptions struct {
+	AdminPassword string            `secret:"true" env:"TEST_FILE_ADMIN_PASSWORD"`
+	AdminUser     string            `default:"admin"`
+	Token         string            `secret:"true" file:"false"`
+	CaCerts       []string          `file:"true"`
+	Labels        map[string]string `file:"true"`
+	Debug         bool              `secret:"true"`
+}
+
+func TestFileArguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pw := writeTestFile(t, dir, "pw", "s3cret\r\n\n")
+	pw2 := writeTestFile(t, dir, "pw2", "other")
+	certs := writeTestFile(t, dir, "certs", "a.pem\n\nb.pem\n")
+	labels := writeTestFile(t, dir, "labels", "k1=v1\nk2=v2")
+
+	t.Run("tokens", func(t *testing.T) {
+		p := mustNewParser(t, &fileTestOptions{})
+		for _, token := range []string{"admin-password-file", "ca-certs-file", "labels-file"} {
+			if arg, _, _ := p.findOptionalArgument(token, true); arg == nil {
+				t.Errorf("missing argument %s", token)
+			}
+		}
+		for _, token := range []string{"admin-user-file", "token-file", "debug-file"} {
+			if arg, _, _ := p.findOptionalArgument(token, true); arg != nil {
+				t.Errorf("unexpected argument %s", token)
+			}
+		}
+		if usage := p.Usage(); !strings.Contains(usage, "[--admin-password-file FILE]") {
+			t.Errorf("usage without --admin-password-file: %s", usage)
+		}
+	})
+	t.Run("cli", func(t *testing.T) {
+		s := &fileTestOptions{}
+		p := mustNewParser(t, s)
+		err := p.ParseArgs([]string{"--admin-password-file", pw, "--ca-certs-file", certs, "--labels-file", labels}, false)
+		if err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		want := fileTestOptions{
+			AdminPassword: "s3cret",
+			AdminUser:     "admin",
+			CaCerts:       []string{"a.pem", "b.pem"},
+			Labels:        map[string]string{"k1": "v1", "k2": "v2"},
+		}
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+		if src := p.Source("admin-password"); src.Kind != SourceCLI {
+			t.Errorf("want source cli, got %s", src)
+		}
+	})
+	t.Run("precedence", func(t *testing.T) {
+		conf := writeTestFile(t, dir, "region.conf", "admin_password_file = '"+pw2+"'\n")
+		defer setEnvs(t, map[string]string{"TEST_FILE_ADMIN_PASSWORD_FILE": pw})()
+		s := &fileTestOptions{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p.ParseFile(conf); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+		// the environment takes precedence over config files
+		if s.AdminPassword != "s3cret" {
+			t.Errorf("want password from env file, got %q", s.AdminPassword)
+		}
+
+		s = &fileTestOptions{}
+		p = mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--admin-password", "cli"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if err := p.ParseFile(conf); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+		if s.AdminPassword != "cli" {
+			t.Errorf("want password from cli, got %q", s.AdminPassword)
+		}
+
+		s = &fileTestOptions{}
+		p = mustNewParser(t, s)
+		if err := p.Load(FileLayer(conf), EnvLayer()); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if s.AdminPassword != "s3cret" {
+			t.Errorf("want password from env file, got %q", s.AdminPassword)
+		}
+	})
+	t.Run("load arrays", func(t *testing.T) {
+		hosts := writeTestFile(t, dir, "hosts", "h1\nh2\n")
+		conf := writeTestFile(t, dir, "hosts.conf", "hosts_file = '"+hosts+"'\n")
+		type hostsOptions struct {
+			Hosts []string `default:"a" file:"true"`
+		}
+		cases := []struct {
+			name   string
+			layers []ConfigLayer
+			want   []string
+		}{
+			{"default", []ConfigLayer{DefaultsLayer(), ArgsLayer([]string{"--hosts-file", hosts}, false)}, []string{"h1", "h2"}},
+			{"lower layer", []ConfigLayer{ArgsLayer([]string{"--hosts", "c"}, false), FileLayer(conf)}, []string{"h1", "h2"}},
+			{"higher layer", []ConfigLayer{DefaultsLayer(), FileLayer(conf), ArgsLayer([]string{"--hosts", "c"}, false)}, []string{"c"}},
+		}
+		for _, c := range cases {
+			s := &hostsOptions{}
+			if err := mustNewParser(t, s).Load(c.layers...); err != nil {
+				t.Fatalf("%s: Load: %v", c.name, err)
+			}
+			if !reflect.DeepEqual(s.Hosts, c.want) {
+				t.Errorf("%s: want %v, got %v", c.name, c.want, s.Hosts)
+			}
+		}
+	})
+	t.Run("missing file", func(t *testing.T) {
+		err := mustNewParser(t, &fileTestOptions{}).ParseArgs([]string{"--admin-password-file", pw + ".nonexist"}, false)
+		var verr *InvalidValueError
+		if !errorAs(err, &verr) || verr.Argument.Token() != "admin-password-file" || !os.IsNotExist(verr.Err) {
+			t.Errorf("want not exist error, got %v", err)
+		}
+	})
+	t.Run("taken token", func(t *testing.T) {
+		s := &struct {
+			Password     string `secret:"true"`
+			PasswordFile string
+		}{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--password-file", pw}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		if s.PasswordFile != pw || s.Password != "" {
+			t.Errorf("--password-file should set the field, got %#v", s)
+		}
+	})
+}
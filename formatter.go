@@ -0,0 +1,368 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	// indentation of arguments in the help
+	helpArgIndent = 4
+	// the help text of arguments starts at most at this column, longer
+	// arguments have the help text on the next line
+	maxHelpColumn = 30
+	// the help text is wrapped at no less than this width
+	minHelpTextWidth = 20
+)
+
+// HelpFormatter renders the usage and help of a parser from its model, set
+// by SetHelpFormatter
+type HelpFormatter interface {
+	FormatUsage(model *HelpModel) string
+	FormatHelp(model *HelpModel) string
+}
+
+// DefaultHelpFormatter lists the arguments with their annotated help text
+// aligned in a column, and the subcommands under the headings of their
+// categories
+type DefaultHelpFormatter struct{}
+
+// CompactHelpFormatter lists the subcommands and arguments with the first
+// line of their help text only, without annotations, and the optional
+// arguments as "[options]" in the usage
+type CompactHelpFormatter struct{}
+
+// VerboseHelpFormatter lists the arguments with their full help text below
+// them, followed by their annotations one per line
+type VerboseHelpFormatter struct{}
+
+func (f DefaultHelpFormatter) FormatUsage(model *HelpModel) string {
+	items := make([]string, 0, len(model.Optional)+len(model.Positional))
+	for _, arg := range model.Optional {
+		items = append(items, arg.Usage)
+	}
+	return formatUsage(model, items)
+}
+
+func (f DefaultHelpFormatter) FormatHelp(model *HelpModel) string {
+	var buf bytes.Buffer
+	width := model.Width
+	buf.WriteString(f.FormatUsage(model))
+	buf.WriteString(wrapText(model.Description, width))
+	buf.WriteByte('\n')
+	buf.WriteByte('\n')
+	names := make([]string, 0, len(model.Positional)+len(model.Optional))
+	for _, args := range [][]HelpArgument{model.Positional, model.Optional} {
+		for _, arg := range args {
+			if arg.Subcommands == nil {
+				names = append(names, arg.Usage)
+			}
+		}
+	}
+	column := helpColumn(helpArgIndent, names, width)
+	for _, section := range []struct {
+		title string
+		args  []HelpArgument
+	}{
+		{"Positional arguments:\n", model.Positional},
+		{"Optional arguments:\n", model.Optional},
+	} {
+		if len(section.args) == 0 {
+			continue
+		}
+		buf.WriteString(section.title)
+		for _, arg := range section.args {
+			if arg.Subcommands != nil {
+				buf.WriteString(strings.Repeat(" ", helpArgIndent))
+				buf.WriteString(arg.Usage)
+				buf.WriteByte('\n')
+				writeSubcommandGroups(&buf, arg.Subcommands, helpArgIndent*2, width)
+				continue
+			}
+			writeHelpRow(&buf, helpArgIndent, arg.Usage, annotateHelp(arg.Help, arg.Annotations), column, width)
+		}
+		buf.WriteByte('\n')
+	}
+	if len(model.Epilog) > 0 {
+		buf.WriteString(wrapText(model.Epilog, width))
+		buf.WriteByte('\n')
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func (f CompactHelpFormatter) FormatUsage(model *HelpModel) string {
+	items := make([]string, 0, len(model.Positional)+1)
+	if len(model.Optional) > 0 {
+		items = append(items, "[options]")
+	}
+	return formatUsage(model, items)
+}
+
+func (f CompactHelpFormatter) FormatHelp(model *HelpModel) string {
+	var buf bytes.Buffer
+	width := model.Width
+	indent := 2
+	buf.WriteString(f.FormatUsage(model))
+	if len(model.Description) > 0 {
+		buf.WriteString(firstLine(model.Description))
+		buf.WriteByte('\n')
+		buf.WriteByte('\n')
+	}
+	commands := make([]HelpSubcommand, 0)
+	positional := make([]HelpArgument, 0, len(model.Positional))
+	for _, arg := range model.Positional {
+		if arg.Subcommands == nil {
+			positional = append(positional, arg)
+			continue
+		}
+		for _, group := range arg.Subcommands {
+			commands = append(commands, group.Subcommands...)
+		}
+	}
+	names := make([]string, 0)
+	for _, cmd := range commands {
+		names = append(names, cmd.Name)
+	}
+	for _, args := range [][]HelpArgument{positional, model.Optional} {
+		for _, arg := range args {
+			names = append(names, trimUsageBrackets(arg.Usage))
+		}
+	}
+	column := helpColumn(indent, names, width)
+	if len(commands) > 0 {
+		buf.WriteString("Commands:\n")
+		for _, cmd := range commands {
+			writeHelpRow(&buf, indent, cmd.Name, cmd.Description, column, width)
+		}
+		buf.WriteByte('\n')
+	}
+	for _, section := range []struct {
+		title string
+		args  []HelpArgument
+	}{
+		{"Arguments:\n", positional},
+		{"Options:\n", model.Optional},
+	} {
+		if len(section.args) == 0 {
+			continue
+		}
+		buf.WriteString(section.title)
+		for _, arg := range section.args {
+			writeHelpRow(&buf, indent, trimUsageBrackets(arg.Usage), firstLine(arg.Help), column, width)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+func (f VerboseHelpFormatter) FormatUsage(model *HelpModel) string {
+	return DefaultHelpFormatter{}.FormatUsage(model)
+}
+
+func (f VerboseHelpFormatter) FormatHelp(model *HelpModel) string {
+	var buf bytes.Buffer
+	width := model.Width
+	textIndent := strings.Repeat(" ", helpArgIndent*2)
+	textWidth := width - len(textIndent)
+	if textWidth < minHelpTextWidth {
+		textWidth = minHelpTextWidth
+	}
+	buf.WriteString(f.FormatUsage(model))
+	buf.WriteString(wrapText(model.Description, width))
+	buf.WriteByte('\n')
+	buf.WriteByte('\n')
+	for _, section := range []struct {
+		title string
+		args  []HelpArgument
+	}{
+		{"Positional arguments:\n", model.Positional},
+		{"Optional arguments:\n", model.Optional},
+	} {
+		if len(section.args) == 0 {
+			continue
+		}
+		buf.WriteString(section.title)
+		for _, arg := range section.args {
+			buf.WriteString(strings.Repeat(" ", helpArgIndent))
+			buf.WriteString(arg.Usage)
+			buf.WriteByte('\n')
+			lines := make([]string, 0, len(arg.Annotations)+1)
+			if len(arg.Help) > 0 {
+				lines = append(lines, wrapText(arg.Help, textWidth))
+			}
+			lines = append(lines, arg.Annotations...)
+			for _, line := range lines {
+				buf.WriteString(textIndent)
+				buf.WriteString(strings.Replace(line, "\n", "\n"+textIndent, -1))
+				buf.WriteByte('\n')
+			}
+			for _, group := range arg.Subcommands {
+				cmdIndent := textIndent
+				if len(group.Category) > 0 {
+					buf.WriteString(textIndent)
+					buf.WriteString(group.Category)
+					buf.WriteString(":\n")
+					cmdIndent += "  "
+				}
+				for _, cmd := range group.Subcommands {
+					buf.WriteString(cmdIndent)
+					buf.WriteString(cmd.Name)
+					buf.WriteByte('\n')
+					if len(cmd.Description) > 0 {
+						buf.WriteString(cmdIndent)
+						buf.WriteString("    ")
+						buf.WriteString(cmd.Description)
+						buf.WriteByte('\n')
+					}
+				}
+			}
+			buf.WriteByte('\n')
+		}
+	}
+	if len(model.Epilog) > 0 {
+		buf.WriteString(wrapText(model.Epilog, width))
+		buf.WriteByte('\n')
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// formatUsage wraps the usage of the program with the items followed by
+// the positional arguments
+func formatUsage(model *HelpModel, items []string) string {
+	var buf bytes.Buffer
+	for _, arg := range model.Positional {
+		item := arg.Usage
+		if arg.Argument.IsSubcommand() || arg.Argument.IsMulti() {
+			item += " ..."
+		}
+		items = append(items, item)
+	}
+	width := model.Width
+	prefix := "Usage: " + model.Prog
+	// continuation lines are aligned after the program name, unless it is
+	// too long
+	indent := displayWidth(prefix) + 1
+	if indent > width/2 {
+		indent = len("Usage: ")
+	}
+	buf.WriteString(prefix)
+	col := displayWidth(prefix)
+	for _, item := range items {
+		if col > indent && col+1+displayWidth(item) > width {
+			buf.WriteByte('\n')
+			buf.WriteString(strings.Repeat(" ", indent))
+			col = indent
+		} else {
+			buf.WriteByte(' ')
+			col++
+		}
+		buf.WriteString(item)
+		col += displayWidth(item)
+	}
+	buf.WriteByte('\n')
+	buf.WriteByte('\n')
+	return buf.String()
+}
+
+// helpColumn returns the column the help text of the names at the indent
+// is aligned at, after the longest name that fits before maxHelpColumn
+func helpColumn(indent int, names []string, width int) int {
+	limit := maxHelpColumn
+	if limit > width-minHelpTextWidth {
+		limit = width - minHelpTextWidth
+	}
+	column := 0
+	for _, name := range names {
+		if c := indent + displayWidth(name) + 2; c > column && c <= limit {
+			column = c
+		}
+	}
+	if column == 0 {
+		column = indent + helpArgIndent
+	}
+	return column
+}
+
+// writeSubcommandGroups writes the subcommands with their descriptions
+// aligned, under the headings of their categories
+func writeSubcommandGroups(buf *bytes.Buffer, groups []HelpSubcommandGroup, indent int, width int) {
+	// measured from the indent of the arguments, for the same room of names
+	// as the arguments
+	names := make([]string, 0)
+	for _, group := range groups {
+		prefix := ""
+		if len(group.Category) > 0 {
+			prefix = "  "
+		}
+		for _, cmd := range group.Subcommands {
+			names = append(names, prefix+cmd.Name)
+		}
+	}
+	column := helpColumn(indent-helpArgIndent, names, width) + helpArgIndent
+	for _, group := range groups {
+		cmdIndent := indent
+		if len(group.Category) > 0 {
+			buf.WriteString(strings.Repeat(" ", indent))
+			buf.WriteString(group.Category)
+			buf.WriteString(":\n")
+			cmdIndent += 2
+		}
+		for _, cmd := range group.Subcommands {
+			writeHelpRow(buf, cmdIndent, cmd.Name, cmd.Description, column, width)
+		}
+	}
+}
+
+// writeHelpRow writes the name at the indent and the text wrapped at the
+// column
+func writeHelpRow(buf *bytes.Buffer, indent int, name string, text string, column int, width int) {
+	buf.WriteString(strings.Repeat(" ", indent))
+	buf.WriteString(name)
+	if len(text) == 0 {
+		buf.WriteByte('\n')
+		return
+	}
+	pad := strings.Repeat(" ", column)
+	textWidth := width - column
+	if textWidth < minHelpTextWidth {
+		textWidth = minHelpTextWidth
+	}
+	wrapped := wrapText(text, textWidth)
+	if nameWidth := displayWidth(name); indent+nameWidth+2 > column {
+		buf.WriteByte('\n')
+		buf.WriteString(pad)
+	} else {
+		buf.WriteString(strings.Repeat(" ", column-indent-nameWidth))
+	}
+	buf.WriteString(strings.Replace(wrapped, "\n", "\n"+pad, -1))
+	buf.WriteByte('\n')
+}
+
+// trimUsageBrackets returns the argument in the usage without the
+// brackets, e.g. "--port PORT" for "[--port PORT]"
+func trimUsageBrackets(usage string) string {
+	if len(usage) >= 2 && (usage[0] == '[' && usage[len(usage)-1] == ']' || usage[0] == '<' && usage[len(usage)-1] == '>') {
+		return usage[1 : len(usage)-1]
+	}
+	return usage
+}
+
+func firstLine(text string) string {
+	return strings.Split(text, "\n")[0]
+}
@@ -0,0 +1,145 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCompactHelpFormatter(t *testing.T) {
+	p := newHelpTestParser(t)
+	p.SetHelpWidth(60)
+	p.SetHelpFormatter(CompactHelpFormatter{})
+	want := `Usage: prog [options] <SUBCOMMAND> ...
+
+prog desc
+
+Commands:
+  version          Show version
+  server-list      List servers
+
+Options:
+  --port PORT      Listen port
+  --very-long-option-name-here VERY_LONG_OPTION_NAME_HERE
+                   A long option
+  --debug          Show debug information
+  --help           Print usage and this help message and
+                   exit.
+  --region REGION  Region name of the cloud, used to select
+                   the endpoints of the services in the
+                   catalog
+
+`
+	if got := p.HelpString(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+	subp := p.GetSubcommand().subcommands["version"].parser
+	if got, want := subp.Usage(), "Usage: prog version [options]\n\n"; got != want {
+		t.Errorf("sub parser usage: want %q, got %q", want, got)
+	}
+}
+
+func TestVerboseHelpFormatter(t *testing.T) {
+	p := newHelpTestParser(t)
+	p.SetHelpWidth(60)
+	p.SetHelpFormatter(VerboseHelpFormatter{})
+	want := `Usage: prog [--port PORT]
+            [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE]
+            [--debug] [--help] [--region REGION]
+            <SUBCOMMAND> ...
+
+prog desc
+
+Positional arguments:
+    <SUBCOMMAND>
+        version
+            Show version
+        Servers:
+          server-list
+              List servers
+
+Optional arguments:
+    [--port PORT]
+        Listen port
+        default: 8080
+
+    [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE]
+        A long option
+
+    [--debug]
+        Show debug information
+        in the log
+
+    [--help]
+        Print usage and this help message and exit.
+
+    [--region REGION]
+        Region name of the cloud, used to select the
+        endpoints of the services in the catalog
+
+prog epilog
+
+`
+	if got := p.HelpString(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+type markdownHelpFormatter struct{}
+
+func (f markdownHelpFormatter) FormatUsage(model *HelpModel) string {
+	return fmt.Sprintf("`%s`\n", model.Prog)
+}
+
+func (f markdownHelpFormatter) FormatHelp(model *HelpModel) string {
+	lines := []string{"# " + f.FormatUsage(model)}
+	for _, arg := range model.Positional {
+		for _, group := range arg.Subcommands {
+			for _, cmd := range group.Subcommands {
+				lines = append(lines, fmt.Sprintf("* %s %s: %s", group.Category, cmd.Name, cmd.Description))
+			}
+		}
+	}
+	for _, arg := range model.Optional {
+		lines = append(lines, fmt.Sprintf("* `--%s` %s %v", arg.Argument.Token(), firstLine(arg.Help), arg.Annotations))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCustomHelpFormatter(t *testing.T) {
+	p := newHelpTestParser(t)
+	p.SetHelpFormatter(markdownHelpFormatter{})
+	want := "# `prog`\n\n" +
+		"*  version: Show version\n" +
+		"* Servers server-list: List servers\n" +
+		"* `--port` Listen port [default: 8080]\n" +
+		"* `--very-long-option-name-here` A long option []\n" +
+		"* `--debug` Show debug information []\n" +
+		"* `--help` Print usage and this help message and exit. []\n" +
+		"* `--region` Region name of the cloud, used to select the endpoints of the services in the catalog []"
+	if got := p.HelpString(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+	subp := p.GetSubcommand().subcommands["server-list"].parser
+	if got, want := subp.Usage(), "`prog server-list`\n"; got != want {
+		t.Errorf("sub parser usage: want %q, got %q", want, got)
+	}
+	p.SetHelpFormatter(nil)
+	if !strings.HasPrefix(subp.Usage(), "Usage: prog server-list [--help]") {
+		t.Errorf("expecting default formatter, got %q", subp.Usage())
+	}
+}
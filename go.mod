@@ -3,6 +3,7 @@ module yunion.io/x/structarg
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/texttheater/golang-levenshtein v0.0.0-20180516184445-d188e65d659e
 	yunion.io/x/jsonutils v0.0.0-20190625054549-a964e1e8a051
 	yunion.io/x/log v0.0.0-20190514041436-04ce53b17c6b
@@ -0,0 +1,423 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"yunion.io/x/pkg/utils"
+)
+
+// DefaultHelpWidth is the width of the help when the terminal width is
+// unknown, e.g. the output is not a terminal
+const DefaultHelpWidth = 80
+
+// HelpAnnotation is a set of details of the arguments appended to their
+// help text, e.g. "(default: 600, env: AUTH_URL, one of: a|b)"
+type HelpAnnotation int
+
+const (
+	// HelpAnnotateRequired marks required optional arguments
+	HelpAnnotateRequired HelpAnnotation = 1 << iota
+	// HelpAnnotateDefault shows the default values, RedactedValue for
+	// secret arguments
+	HelpAnnotateDefault
+	// HelpAnnotateEnv shows the bound environment variables
+	HelpAnnotateEnv
+	// HelpAnnotateChoices shows the choices
+	HelpAnnotateChoices
+	// HelpAnnotateAliases shows the alias tokens
+	HelpAnnotateAliases
+	// HelpAnnotateCount shows the number of values of array and map
+	// arguments, as in the nargs tag
+	HelpAnnotateCount
+
+	HelpAnnotateNone HelpAnnotation = 0
+	HelpAnnotateAll                 = HelpAnnotateRequired | HelpAnnotateDefault | HelpAnnotateEnv |
+		HelpAnnotateChoices | HelpAnnotateAliases | HelpAnnotateCount
+)
+
+// SetHelpAnnotations sets the details appended to the help text of the
+// arguments, HelpAnnotateAll by default.  The setting also applies to sub
+// parsers.
+func (this *ArgumentParser) SetHelpAnnotations(annotations HelpAnnotation) {
+	this.noHelpAnnotations = HelpAnnotateAll &^ annotations
+	for _, subparser := range this.subParsers() {
+		subparser.SetHelpAnnotations(annotations)
+	}
+}
+
+func (this *ArgumentParser) helpAnnotations() HelpAnnotation {
+	return HelpAnnotateAll &^ this.noHelpAnnotations
+}
+
+// helpAnnotation returns the details of the argument selected by the
+// annotations
+func (this *SingleArgument) helpAnnotation(annotations HelpAnnotation) []string {
+	ret := make([]string, 0)
+	if annotations&HelpAnnotateRequired != 0 && this.required && !this.positional {
+		ret = append(ret, "required")
+	}
+	if annotations&HelpAnnotateDefault != 0 {
+		if len(this.defEnvNames) > 0 {
+			// the value of the environment is not shown
+			if len(this.defLiteral) > 0 {
+				ret = append(ret, "default: "+redactString(this, this.defLiteral))
+			}
+		} else if this.useDefault {
+			ret = append(ret, "default: "+this.defaultString())
+		}
+	}
+	if annotations&HelpAnnotateEnv != 0 {
+		envNames := make([]string, 0, len(this.defEnvNames)+1)
+		if envName := argEnvName(this); len(envName) > 0 {
+			envNames = append(envNames, envName)
+		}
+		for _, name := range this.defEnvNames {
+			if !utils.IsInStringArray(name, envNames) {
+				envNames = append(envNames, name)
+			}
+		}
+		if len(envNames) > 0 {
+			ret = append(ret, "env: "+strings.Join(envNames, "|"))
+		}
+	}
+	if annotations&HelpAnnotateChoices != 0 && len(this.choices) > 0 {
+		ret = append(ret, "one of: "+strings.Join(this.choices, "|"))
+	}
+	if annotations&HelpAnnotateAliases != 0 && len(this.aliasToken) > 0 {
+		ret = append(ret, "alias: --"+this.AliasToken())
+	}
+	return ret
+}
+
+func (this *MultiArgument) helpAnnotation(annotations HelpAnnotation) []string {
+	ret := this.SingleArgument.helpAnnotation(annotations)
+	if annotations&HelpAnnotateCount == 0 {
+		return ret
+	}
+	var count string
+	switch {
+	case this.minCount <= 0 && this.maxCount < 0:
+		// any number of values
+	case this.minCount == this.maxCount:
+		count = strconv.FormatInt(this.minCount, 10)
+	case this.maxCount < 0:
+		count = fmt.Sprintf("at least %d", this.minCount)
+	case this.minCount <= 0:
+		count = fmt.Sprintf("at most %d", this.maxCount)
+	default:
+		count = fmt.Sprintf("%d to %d", this.minCount, this.maxCount)
+	}
+	if len(count) > 0 {
+		ret = append(ret, "values: "+count)
+	}
+	return ret
+}
+
+// helpAnnotation returns no details, the subcommands are listed instead of
+// the choices
+func (this *SubcommandArgument) helpAnnotation(annotations HelpAnnotation) []string {
+	return nil
+}
+
+// defaultString formats the default value for the help, the default with
+// references unexpanded
+func (this *SingleArgument) defaultString() string {
+	if len(this.defTemplate) > 0 {
+		return redactString(this, this.defTemplate)
+	}
+	value := this.defValue
+	for value.Kind() == reflect.Ptr && !value.IsNil() {
+		value = value.Elem()
+	}
+	return fmt.Sprint(redactValue(this, value))
+}
+
+// annotateHelp returns the help text followed by the annotations
+func annotateHelp(help string, annotations []string) string {
+	if len(annotations) == 0 {
+		return help
+	}
+	annotation := "(" + strings.Join(annotations, ", ") + ")"
+	if len(help) == 0 {
+		return annotation
+	}
+	return help + " " + annotation
+}
+
+// SetHelpWidth sets the width the usage and help are wrapped at, 0 to
+// detect the width from the COLUMNS environment variable or the terminal,
+// DefaultHelpWidth if neither is available.  The setting also applies to
+// sub parsers.
+func (this *ArgumentParser) SetHelpWidth(width int) {
+	this.helpWidth = width
+	for _, subparser := range this.subParsers() {
+		subparser.SetHelpWidth(width)
+	}
+}
+
+func (this *ArgumentParser) getHelpWidth() int {
+	if this.helpWidth > 0 {
+		return this.helpWidth
+	}
+	return terminalWidth()
+}
+
+// terminalWidth returns the width in COLUMNS, or of the terminal of stdout
+func terminalWidth() int {
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	if width := getTerminalWidth(os.Stdout.Fd()); width > 0 {
+		return width
+	}
+	return DefaultHelpWidth
+}
+
+// HelpModel is the structured model of a parser rendered by a
+// HelpFormatter
+type HelpModel struct {
+	Prog        string
+	Description string
+	Epilog      string
+	// Width is the width the output should be wrapped at
+	Width      int
+	Positional []HelpArgument
+	Optional   []HelpArgument
+}
+
+// HelpArgument is an argument in the HelpModel
+type HelpArgument struct {
+	Argument Argument
+	// Usage is the argument as in the usage, e.g. "[--port PORT]"
+	Usage string
+	// Help is the help text without the annotations
+	Help string
+	// Annotations are the details selected by SetHelpAnnotations, e.g.
+	// "default: 600"
+	Annotations []string
+	// Subcommands are the subcommands of a subcommand argument grouped by
+	// category, in the order set by SetSubcommandOrder
+	Subcommands []HelpSubcommandGroup
+}
+
+// HelpSubcommandGroup is the subcommands of a category, the uncategorized
+// subcommands are the first group with an empty Category
+type HelpSubcommandGroup struct {
+	Category    string
+	Subcommands []HelpSubcommand
+}
+
+type HelpSubcommand struct {
+	Name string
+	// Description is the short description of the sub parser
+	Description string
+}
+
+// SetHelpFormatter sets the formatter of the usage and help,
+// DefaultHelpFormatter if nil.  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetHelpFormatter(formatter HelpFormatter) {
+	this.helpFormatter = formatter
+	for _, subparser := range this.subParsers() {
+		subparser.SetHelpFormatter(formatter)
+	}
+}
+
+func (this *ArgumentParser) getHelpFormatter() HelpFormatter {
+	if this.helpFormatter == nil {
+		return DefaultHelpFormatter{}
+	}
+	return this.helpFormatter
+}
+
+// HelpModel returns the model of the parser for the HelpFormatter
+func (this *ArgumentParser) HelpModel() *HelpModel {
+	model := &HelpModel{
+		Prog:        this.prog,
+		Description: this.description,
+		Epilog:      this.epilog,
+		Width:       this.getHelpWidth(),
+		Positional:  make([]HelpArgument, 0, len(this.posArgs)),
+		Optional:    make([]HelpArgument, 0, len(this.optArgs)),
+	}
+	annotations := this.helpAnnotations()
+	for _, arg := range this.posArgs {
+		model.Positional = append(model.Positional, newHelpArgument(arg, annotations))
+	}
+	for _, arg := range this.optArgs {
+		model.Optional = append(model.Optional, newHelpArgument(arg, annotations))
+	}
+	return model
+}
+
+func newHelpArgument(arg Argument, annotations HelpAnnotation) HelpArgument {
+	ret := HelpArgument{
+		Argument: arg,
+		Usage:    arg.String(),
+	}
+	if subcmd, ok := arg.(*SubcommandArgument); ok {
+		ret.Help = subcmd.SingleArgument.HelpString("")
+		ret.Subcommands = subcmd.helpSubcommands()
+	} else {
+		ret.Help = arg.HelpString("")
+	}
+	if annotated, ok := arg.(interface {
+		helpAnnotation(HelpAnnotation) []string
+	}); ok {
+		ret.Annotations = annotated.helpAnnotation(annotations)
+	}
+	return ret
+}
+
+// helpSubcommands returns the subcommands grouped by category
+func (this *SubcommandArgument) helpSubcommands() []HelpSubcommandGroup {
+	categories, commands := this.sortedSubcommands()
+	ret := make([]HelpSubcommandGroup, 0, len(categories))
+	for _, category := range categories {
+		group := HelpSubcommandGroup{Category: category}
+		for _, cmd := range commands[category] {
+			group.Subcommands = append(group.Subcommands, HelpSubcommand{
+				Name:        cmd,
+				Description: this.subcommands[cmd].parser.ShortDescription(),
+			})
+		}
+		ret = append(ret, group)
+	}
+	return ret
+}
+
+func (this *ArgumentParser) Usage() string {
+	return this.getHelpFormatter().FormatUsage(this.HelpModel())
+}
+
+func (this *ArgumentParser) HelpString() string {
+	return this.getHelpFormatter().FormatHelp(this.HelpModel())
+}
+
+// wrapText wraps each line of the text at the display width, the wrapped
+// lines keep the leading spaces of the line and the spaces between the
+// words on the same line.  Words longer than the width are not broken, a
+// line may be wrapped between wide characters, e.g. of Chinese.
+func wrapText(text string, width int) string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(line, " \t")
+		lead := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		leadWidth := displayWidth(lead)
+		cur, col := lead, leadWidth
+		space, started := "", false
+		for _, word := range splitWords(line[len(lead):]) {
+			if isSpaceRune(rune(word[0])) {
+				space = word
+				continue
+			}
+			wordWidth := displayWidth(word)
+			if started && col+displayWidth(space)+wordWidth > width {
+				lines = append(lines, cur)
+				cur, col = lead+word, leadWidth+wordWidth
+			} else {
+				cur += space + word
+				col += displayWidth(space) + wordWidth
+			}
+			space, started = "", true
+		}
+		lines = append(lines, cur)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isSpaceRune(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// splitWords splits a line into words and runs of spaces, each wide
+// character is a word
+func splitWords(line string) []string {
+	words := make([]string, 0)
+	kind := func(r rune) int {
+		switch {
+		case isSpaceRune(r):
+			return 1
+		case isWideRune(r):
+			return 2
+		}
+		return 0
+	}
+	start, prev := 0, -1
+	for i, r := range line {
+		k := kind(r)
+		if i > start && (k != prev || k == 2) {
+			words = append(words, line[start:i])
+			start = i
+		}
+		prev = k
+	}
+	if start < len(line) {
+		words = append(words, line[start:])
+	}
+	return words
+}
+
+// displayWidth returns the columns taken by the text on a terminal, wide
+// characters take 2 columns and combining marks none
+func displayWidth(text string) int {
+	width := 0
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf):
+		case isWideRune(r):
+			width += 2
+		default:
+			width++
+		}
+	}
+	return width
+}
+
+// wideRanges are the East Asian wide and fullwidth characters
+var wideRanges = []struct {
+	lo, hi rune
+}{
+	{0x1100, 0x115F},
+	{0x2E80, 0x303E},
+	{0x3041, 0x33FF},
+	{0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF},
+	{0xA000, 0xA4CF},
+	{0xAC00, 0xD7A3},
+	{0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F},
+	{0xFF00, 0xFF60},
+	{0xFFE0, 0xFFE6},
+	{0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF},
+	{0x20000, 0x2FFFD},
+	{0x30000, 0x3FFFD},
+}
+
+func isWideRune(r rune) bool {
+	for _, rg := range wideRanges {
+		if r >= rg.lo && r <= rg.hi {
+			return true
+		}
+	}
+	return false
+}
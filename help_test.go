@@ -0,0 +1,252 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+type helpTestOptions struct {
+	Region                 string `help:"Region name of the cloud, used to select the endpoints of the services in the catalog"`
+	Port                   int    `default:"8080" help:"Listen port"`
+	VeryLongOptionNameHere string `help:"A long option"`
+	Debug                  bool   `help:"Show debug information\nin the log"`
+	SUBCOMMAND             string `subcommand:"true"`
+}
+
+func newHelpTestParser(t *testing.T) *ArgumentParser {
+	p := mustNewParser(t, &helpTestOptions{})
+	callback := func(opts *struct{}) error { return nil }
+	subcmd := p.GetSubcommand()
+	subcmd.AddSubParser(&struct{}{}, "version", "Show version", callback)
+	subcmd.AddSubParserWithCategory(&struct{}{}, "server-list", "List servers", "Servers", callback)
+	return p
+}
+
+func TestHelpString(t *testing.T) {
+	p := newHelpTestParser(t)
+	p.SetHelpWidth(60)
+	want := `Usage: prog [--port PORT]
+            [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE]
+            [--debug] [--help] [--region REGION]
+            <SUBCOMMAND> ...
+
+prog desc
+
+Positional arguments:
+    <SUBCOMMAND>
+        version        Show version
+        Servers:
+          server-list  List servers
+
+Optional arguments:
+    [--port PORT]      Listen port (default: 8080)
+    [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE]
+                       A long option
+    [--debug]          Show debug information
+                       in the log
+    [--help]           Print usage and this help message and
+                       exit.
+    [--region REGION]  Region name of the cloud, used to
+                       select the endpoints of the services
+                       in the catalog
+
+prog epilog
+
+`
+	if got := p.HelpString(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+	subp := p.GetSubcommand().subcommands["version"].parser
+	if subp.helpWidth != 60 {
+		t.Errorf("help width of sub parser: want 60, got %d", subp.helpWidth)
+	}
+}
+
+// modelArgumentHelp returns the annotated help of the optional argument in
+// the help model
+func modelArgumentHelp(t *testing.T, p *ArgumentParser, token string) string {
+	for _, arg := range p.HelpModel().Optional {
+		if arg.Argument.Token() == token {
+			return annotateHelp(arg.Help, arg.Annotations)
+		}
+	}
+	t.Fatalf("argument %s not found", token)
+	return ""
+}
+
+func TestHelpAnnotations(t *testing.T) {
+	s := &struct {
+		Timeout     int      `default:"600" env:"TIMEOUT" help:"Timeout in seconds"`
+		Interface   string   `default:"publicURL" choices:"publicURL|internalURL" alias:"endpoint-type"`
+		Password    string   `default:"changeme" secret:"true" help:"Admin password"`
+		AuthUrl     string   `required:"true" help:"Keystone URL"`
+		LogDir      string   `default:"${data-dir}/log"`
+		DataDir     string   `default:"/var/lib/app"`
+		Servers     []string `nargs:"+"`
+		Tags        []string `nargs:"2"`
+		Labels      []string
+		PidFile     string
+		DNSResolver []string `default:"8.8.8.8"`
+	}{}
+	p := mustNewParser(t, s)
+	p.SetHelpWidth(200)
+	p.SetEnvPrefix("APP_")
+	for _, c := range []struct {
+		token string
+		want  string
+	}{
+		{"timeout", "Timeout in seconds (default: 600, env: TIMEOUT)"},
+		{"interface", "(default: publicURL, env: APP_INTERFACE, one of: publicURL|internalURL, alias: --endpoint-type)"},
+		{"password", "Admin password (default: ******, env: APP_PASSWORD)"},
+		{"auth-url", "Keystone URL (required, env: APP_AUTH_URL)"},
+		{"log-dir", "(default: ${data-dir}/log, env: APP_LOG_DIR)"},
+		{"servers", "(env: APP_SERVERS, values: at least 1)"},
+		{"tags", "(env: APP_TAGS, values: 2)"},
+		{"labels", "(env: APP_LABELS)"},
+		{"dns-resolver", "(default: [8.8.8.8], env: APP_DNS_RESOLVER)"},
+	} {
+		if got := modelArgumentHelp(t, p, c.token); got != c.want {
+			t.Errorf("%s: want %q, got %q", c.token, c.want, got)
+		}
+	}
+	if !strings.Contains(p.HelpString(), "    [--timeout TIMEOUT]     Timeout in seconds (default: 600, env: TIMEOUT)\n") {
+		t.Errorf("annotation not found in help\n%s", p.HelpString())
+	}
+
+	p.SetHelpAnnotations(HelpAnnotateDefault | HelpAnnotateChoices)
+	if got, want := modelArgumentHelp(t, p, "interface"), "(default: publicURL, one of: publicURL|internalURL)"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+	p.SetHelpAnnotations(HelpAnnotateNone)
+	if got := modelArgumentHelp(t, p, "pid-file"); got != "" {
+		t.Errorf("want no annotation, got %q", got)
+	}
+}
+
+func TestHelpLegacyEnvDefault(t *testing.T) {
+	defer setEnvs(t, map[string]string{"TEST_HELP_AUTH_URL": "http://keystone:5000"})()
+	s := &struct {
+		AuthUrl string `default:"$TEST_HELP_AUTH_URL"`
+		Region  string `default:"$TEST_HELP_REGION|$TEST_HELP_OS_REGION|region0"`
+		Token   string `default:"$TEST_HELP_TOKEN|$TEST_HELP_AUTH_URL"`
+	}{}
+	p := mustNewParser(t, s)
+	p.SetHelpWidth(200)
+	// the variables are shown instead of their values
+	for _, c := range []struct {
+		token string
+		want  string
+	}{
+		{"auth-url", "(env: TEST_HELP_AUTH_URL)"},
+		{"region", "(default: region0, env: TEST_HELP_REGION|TEST_HELP_OS_REGION)"},
+		{"token", "(env: TEST_HELP_TOKEN|TEST_HELP_AUTH_URL)"},
+	} {
+		if got := modelArgumentHelp(t, p, c.token); got != c.want {
+			t.Errorf("%s: want %q, got %q", c.token, c.want, got)
+		}
+	}
+	if help := p.HelpString(); strings.Contains(help, "keystone") {
+		t.Errorf("environment values should not be shown\n%s", help)
+	}
+	if err := p.ParseArgs([]string{}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if s.AuthUrl != "http://keystone:5000" || s.Region != "region0" || s.Token != "http://keystone:5000" {
+		t.Errorf("wrong defaults %#v", s)
+	}
+}
+
+func TestHelpWidthColumns(t *testing.T) {
+	old, ok := os.LookupEnv("COLUMNS")
+	defer func() {
+		if ok {
+			os.Setenv("COLUMNS", old)
+		} else {
+			os.Unsetenv("COLUMNS")
+		}
+	}()
+	p := newHelpTestParser(t)
+	os.Setenv("COLUMNS", "200")
+	want := "Usage: prog [--port PORT] [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE] [--debug] [--help] [--region REGION] <SUBCOMMAND> ...\n\n"
+	if got := p.Usage(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+	os.Setenv("COLUMNS", "40")
+	want = "Usage: prog [--port PORT]\n" +
+		"            [--very-long-option-name-here VERY_LONG_OPTION_NAME_HERE]\n" +
+		"            [--debug] [--help]\n" +
+		"            [--region REGION]\n" +
+		"            <SUBCOMMAND> ...\n\n"
+	if got := p.Usage(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+	p.SetHelpWidth(200)
+	if got := p.getHelpWidth(); got != 200 {
+		t.Errorf("SetHelpWidth should override COLUMNS, got width %d", got)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	cases := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"", 10, ""},
+		{"short", 10, "short"},
+		{"one two three four", 9, "one two\nthree\nfour"},
+		{"first line\n\nsecond", 20, "first line\n\nsecond"},
+		{"  indented text here", 12, "  indented\n  text here"},
+		{"averyveryverylongword x", 8, "averyveryverylongword\nx"},
+		{"keep  the\tspaces  ", 20, "keep  the\tspaces"},
+		{"key:   value   here", 12, "key:   value\nhere"},
+		{"设置监听端口号", 8, "设置监听\n端口号"},
+		{"  监听 port 端口", 8, "  监听\n  port\n  端口"},
+		{"naïve café", 5, "naïve\ncafé"},
+	}
+	for _, c := range cases {
+		if got := wrapText(c.text, c.width); got != c.want {
+			t.Errorf("wrapText(%q, %d): want %q, got %q", c.text, c.width, c.want, got)
+		}
+	}
+}
+
+func TestHelpWideText(t *testing.T) {
+	s := &struct {
+		Region string `metavar:"区域" help:"云平台的区域名称，用于选择服务目录中的端点"`
+		Port   int    `help:"监听端口"`
+	}{}
+	p := mustNewParser(t, s)
+	p.SetHelpWidth(60)
+	p.SetHelpAnnotations(0)
+	help := p.HelpString()
+	for _, line := range strings.Split(help, "\n") {
+		if w := displayWidth(line); w > 60 {
+			t.Errorf("line %q is %d columns wide", line, w)
+		}
+	}
+	// the help texts are aligned after the wide metavar
+	want := "    [--port PORT]    监听端口\n" +
+		"    [--help]         Print usage and this help message and\n" +
+		"                     exit.\n" +
+		"    [--region 区域]  云平台的区域名称，用于选择服务目录中的\n" +
+		"                     端点\n"
+	if !strings.Contains(help, want) {
+		t.Errorf("want\n%s\ngot\n%s", want, help)
+	}
+}
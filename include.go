@@ -0,0 +1,228 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"yunion.io/x/jsonutils"
+	"yunion.io/x/pkg/utils"
+)
+
+// configInclude is a file included by a config source
+type configInclude struct {
+	path string
+	// line of the include directive, 0 if unknown
+	line int
+}
+
+// isIncludeKey reports whether the config key is an include directive, i.e.
+// "include" or "includes" not used as the token of an argument
+func (this *ArgumentParser) isIncludeKey(key string) bool {
+	token := keyToToken(key)
+	if token != "include" && token != "includes" {
+		return false
+	}
+	arg, _, _ := this.findOptionalArgument(token, true)
+	return arg == nil
+}
+
+// dictIncludes returns the files included by a YAML or JSON document
+func (this *ArgumentParser) dictIncludes(dict *jsonutils.JSONDict, src *configSource) ([]configInclude, error) {
+	includes := make([]configInclude, 0)
+	for _, key := range []string{"include", "includes"} {
+		obj, err := dict.Get(key)
+		if err != nil || !this.isIncludeKey(key) {
+			continue
+		}
+		line := src.keyLine(key)
+		var paths []string
+		if array, ok := obj.(*jsonutils.JSONArray); ok {
+			paths = array.GetStringArray()
+		} else {
+			var str string
+			str, err = obj.GetString()
+			paths = []string{str}
+		}
+		if err != nil {
+			return nil, &ConfigError{File: src.name, Line: line, Key: key, Err: err}
+		}
+		for _, p := range paths {
+			includes = append(includes, configInclude{path: p, line: line})
+		}
+	}
+	return includes, nil
+}
+
+// tomlIncludes returns the files included by the top level include keys of
+// a TOML document
+func (this *ArgumentParser) tomlIncludes(entries []tomlEntry, filepath string) ([]configInclude, error) {
+	includes := make([]configInclude, 0)
+	for _, entry := range entries {
+		if len(entry.key) != 1 || !this.isIncludeKey(entry.key[0]) {
+			continue
+		}
+		values, ok := entry.value.([]interface{})
+		if !ok {
+			values = []interface{}{entry.value}
+		}
+		for _, value := range values {
+			p, ok := value.(string)
+			if !ok {
+				return nil, &ConfigError{File: filepath, Line: entry.line, Key: entry.key[0], Err: fmt.Errorf("include path %v is not a string", value)}
+			}
+			includes = append(includes, configInclude{path: p, line: entry.line})
+		}
+	}
+	return includes, nil
+}
+
+// tornadoIncludes returns the files included by a tornado style file, e.g.
+// include = 'base.conf' or includes = ['base.conf', 'site.conf']
+func (this *ArgumentParser) tornadoIncludes(content []byte) []configInclude {
+	includes := make([]configInclude, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(removeComments(scanner.Text()))
+		if len(line) == 0 || line[0] == '[' {
+			continue
+		}
+		key, val, err := line2KeyValue(line)
+		if err != nil || len(val) == 0 || !this.isIncludeKey(key) {
+			continue
+		}
+		if val[0] == '(' {
+			val = strings.Trim(val, "()")
+		} else {
+			val = strings.Trim(val, "[]")
+		}
+		for _, p := range utils.FindWords([]byte(val), 0) {
+			includes = append(includes, configInclude{path: p, line: lineNo})
+		}
+	}
+	return includes
+}
+
+// parseWithIncludes sets the arguments from a config source by parse and
+// from the files it includes.  The values of the source override the
+// values of the included files, later included files override earlier
+// ones.  Relative paths are relative to the directory of the source.
+func (this *ArgumentParser) parseWithIncludes(file string, includes []configInclude, parse func() error) error {
+	if len(includes) == 0 {
+		return this.interpolateSource(parse)
+	}
+	self, err := filepath.Abs(file)
+	if err != nil {
+		return &ConfigError{File: file, Err: err}
+	}
+	this.including = append(this.including, self)
+	defer func() {
+		this.including = this.including[:len(this.including)-1]
+	}()
+	return this.interpolateSource(func() error {
+		return this.parseInOrder(len(includes)+1, func(i int) error {
+			if i == len(includes) {
+				return parse()
+			}
+			return this.parseInclude(file, includes[i])
+		})
+	})
+}
+
+func (this *ArgumentParser) parseInclude(file string, include configInclude) error {
+	incPath := include.path
+	if !filepath.IsAbs(incPath) {
+		incPath = filepath.Join(filepath.Dir(file), incPath)
+	}
+	abs, err := filepath.Abs(incPath)
+	if err != nil {
+		return &ConfigError{File: file, Line: include.line, Key: "include", Err: err}
+	}
+	for i := range this.including {
+		if this.including[i] == abs {
+			files := make([]string, 0, len(this.including)-i+1)
+			files = append(files, this.including[i:]...)
+			files = append(files, abs)
+			return &ConfigError{File: file, Line: include.line, Key: "include", Err: &IncludeCycleError{Files: files}}
+		}
+	}
+	if _, err := os.Stat(incPath); err != nil {
+		return &ConfigError{File: file, Line: include.line, Key: "include", Err: err}
+	}
+	return this.parseFile(incPath)
+}
+
+// parseInOrder calls parse for n config sources so that later sources
+// override earlier ones.  Outside Load the values set first are kept, so
+// the sources are parsed in reverse order.  In Load each source is parsed
+// as a layer of its own.
+func (this *ArgumentParser) parseInOrder(n int, parse func(i int) error) error {
+	if this.argLayers != nil {
+		for i := 0; i < n; i++ {
+			this.loadLayer++
+			if err := parse(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := n - 1; i >= 0; i-- {
+		if err := parse(i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configDirExts are the extensions of the files loaded by ParseConfigDir
+var configDirExts = []string{".conf", ".yaml", ".yml", ".toml", ".json"}
+
+// ParseConfigDir sets the arguments from the config files in a drop-in
+// directory, e.g. /etc/region.d, in lexical order of the file names, so
+// that later files override earlier ones.  Files with the extensions
+// .conf, .yaml, .yml, .toml and .json are loaded, hidden files and
+// sub-directories are skipped.  Each file is parsed by ParseFile.  The
+// directory is parsed again by Reload.
+func (this *ArgumentParser) ParseConfigDir(dir string) error {
+	this.addReloadLayer(dir, ConfigDirLayer(dir))
+	return this.parseConfigDir(dir)
+}
+
+func (this *ArgumentParser) parseConfigDir(dir string) error {
+	this.addConfigFile(dir)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read config dir %s: %v", dir, err)
+	}
+	files := make([]string, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !utils.IsInStringArray(strings.ToLower(filepath.Ext(name)), configDirExts) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	return this.parseInOrder(len(files), func(i int) error {
+		return this.parseFile(files[i])
+	})
+}
@@ -0,0 +1,216 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type includeTestOptions struct {
+	Region   string `default:"region0"`
+	Port     int
+	Timeout  int
+	Networks []string
+	Labels   map[string]string
+}
+
+func TestInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "common"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	writeTestFile(t, dir, "common/base.conf", `
+region = 'base'
+port = 80
+timeout = 10
+networks = ['net1', 'net2']
+`)
+	writeTestFile(t, dir, "common/base.toml", `
+include = "base.conf"
+timeout = 20
+[labels]
+a = "1"
+`)
+	writeTestFile(t, dir, "override.yaml", `
+port: 443
+labels:
+  b: "2"
+`)
+	site := writeTestFile(t, dir, "site.conf", `
+includes = ['common/base.toml', 'override.yaml']
+# the values of the including file take precedence
+port = 8080
+`)
+	want := includeTestOptions{
+		Region:   "base",
+		Port:     8080,
+		Timeout:  20,
+		Networks: []string{"net1", "net2"},
+		Labels:   map[string]string{"a": "1", "b": "2"},
+	}
+	t.Run("ParseFile", func(t *testing.T) {
+		s := &includeTestOptions{}
+		p := mustNewParser(t, s)
+		if err := p.ParseFile(site); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+		// outside Load, a map argument is set by one file as a whole
+		want := want
+		want.Labels = map[string]string{"b": "2"}
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+		wantSources := map[string]string{
+			"region":  filepath.Join(dir, "common/base.conf") + ":2",
+			"port":    site + ":4",
+			"timeout": filepath.Join(dir, "common/base.toml") + ":3",
+		}
+		for token, want := range wantSources {
+			if got := p.Source(token); configPosition(got.Name, got.Line) != want {
+				t.Errorf("%s: want source %s, got %s", token, want, got)
+			}
+		}
+	})
+	t.Run("Load", func(t *testing.T) {
+		s := &includeTestOptions{}
+		p := mustNewParser(t, s)
+		if err := p.Load(DefaultsLayer(), FileLayer(site)); err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+	})
+	t.Run("yaml includes", func(t *testing.T) {
+		s := &includeTestOptions{}
+		p := mustNewParser(t, s)
+		err := p.ParseYAML([]byte("include: " + filepath.Join(dir, "common/base.conf") + "\nport: 22\n"))
+		if err != nil {
+			t.Fatalf("ParseYAML: %v", err)
+		}
+		if s.Region != "base" || s.Port != 22 {
+			t.Errorf("wrong result %#v", s)
+		}
+	})
+	t.Run("cycle", func(t *testing.T) {
+		a := writeTestFile(t, dir, "a.conf", "include = 'b.yaml'\nport = 1\n")
+		writeTestFile(t, dir, "b.yaml", "include: c.conf\n")
+		writeTestFile(t, dir, "c.conf", "include = 'a.conf'\n")
+		err := mustNewParser(t, &includeTestOptions{}).ParseFile(a)
+		if !errorIs(err, ErrIncludeCycle) {
+			t.Fatalf("want include cycle, got %v", err)
+		}
+		var cerr *IncludeCycleError
+		if !errorAs(err, &cerr) || len(cerr.Files) != 4 || cerr.Files[0] != cerr.Files[3] {
+			t.Errorf("wrong include chain %v", err)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		conf := writeTestFile(t, dir, "missing.conf", "port = 1\ninclude = 'nonexist.conf'\n")
+		err := mustNewParser(t, &includeTestOptions{}).ParseFile(conf)
+		var cerr *ConfigError
+		if !errorAs(err, &cerr) || cerr.File != conf || cerr.Line != 2 || !os.IsNotExist(cerr.Err) {
+			t.Errorf("want not exist error at %s:2, got %v", conf, err)
+		}
+	})
+	t.Run("include argument", func(t *testing.T) {
+		s := &struct {
+			Include string
+		}{}
+		conf := writeTestFile(t, dir, "arg.conf", "include = 'common/base.conf'\n")
+		if err := mustNewParser(t, s).ParseFile(conf); err != nil {
+			t.Fatalf("ParseFile: %v", err)
+		}
+		if s.Include != "common/base.conf" {
+			t.Errorf("include should set the argument, got %q", s.Include)
+		}
+	})
+}
+
+func TestParseConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFile(t, dir, "10-base.conf", `
+region = 'base'
+port = 80
+networks = ['net1', 'net2']
+labels = ['a=1', 'b=1']
+`)
+	writeTestFile(t, dir, "20-site.yaml", `
+port: 8080
+networks: [net3]
+labels:
+  b: "2"
+`)
+	writeTestFile(t, dir, "30-local.toml", "timeout = 30\n")
+	writeTestFile(t, dir, ".hidden.conf", "port = 1\n")
+	writeTestFile(t, dir, "README", "port = 2\n")
+	writeTestFile(t, dir, "99-disabled.conf.bak", "port = 3\n")
+	if err := os.Mkdir(filepath.Join(dir, "40-dir.conf"), 0755); err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	want := includeTestOptions{
+		Region:   "base",
+		Port:     8080,
+		Timeout:  30,
+		Networks: []string{"net3"},
+		Labels:   map[string]string{"a": "1", "b": "2"},
+	}
+	t.Run("ParseConfigDir", func(t *testing.T) {
+		s := &includeTestOptions{}
+		p := mustNewParser(t, s)
+		if err := p.ParseConfigDir(dir); err != nil {
+			t.Fatalf("ParseConfigDir: %v", err)
+		}
+		want := want
+		want.Labels = map[string]string{"b": "2"}
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+		if src := p.Source("port"); src.Name != filepath.Join(dir, "20-site.yaml") {
+			t.Errorf("port should come from 20-site.yaml, got %s", src)
+		}
+	})
+	t.Run("Load", func(t *testing.T) {
+		s := &includeTestOptions{}
+		p := mustNewParser(t, s)
+		err := p.Load(DefaultsLayer(), ConfigDirLayer(dir), ArgsLayer([]string{"--timeout", "5"}, false))
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		want := want
+		want.Timeout = 5
+		if !reflect.DeepEqual(*s, want) {
+			t.Errorf("want %#v, got %#v", want, *s)
+		}
+	})
+	t.Run("missing dir", func(t *testing.T) {
+		if err := mustNewParser(t, &includeTestOptions{}).ParseConfigDir(filepath.Join(dir, "nonexist")); err == nil {
+			t.Errorf("want error for missing dir")
+		}
+	})
+}
@@ -0,0 +1,312 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"yunion.io/x/pkg/gotypes"
+)
+
+// hasReference reports whether a value contains a reference or an escaped
+// reference, i.e. "${"
+func hasReference(value string) bool {
+	return strings.Contains(value, "${")
+}
+
+// interpolateSource sets the arguments from a config source by parse.  The
+// values with references are kept as templates while the source and the
+// sources it includes are parsed, and expanded afterwards, so that the
+// references to options set later in the source are resolved.
+func (this *ArgumentParser) interpolateSource(parse func() error) error {
+	if this.templates != nil {
+		return parse()
+	}
+	this.templates = make(map[Argument][]string)
+	defer func() {
+		this.templates = nil
+	}()
+	if err := parse(); err != nil {
+		return err
+	}
+	return this.expandTemplates()
+}
+
+// setConfigValue sets a value of an argument from a config source, the
+// values with references are expanded by interpolateSource
+func (this *ArgumentParser) setConfigValue(arg Argument, value string) error {
+	if this.templates == nil {
+		value, err := this.interpolate(arg, value, nil)
+		if err != nil {
+			return err
+		}
+		return arg.SetValue(value)
+	}
+	pending, ok := this.templates[arg]
+	if !hasReference(value) && (!ok || !arg.IsMulti()) {
+		delete(this.templates, arg)
+		return arg.SetValue(value)
+	}
+	if !arg.IsMulti() {
+		pending = nil
+	}
+	// later values of a multi argument are kept in order after a template
+	this.templates[arg] = append(pending, value)
+	if setArg, ok := arg.(interface {
+		markSet()
+	}); ok {
+		setArg.markSet()
+	}
+	return nil
+}
+
+func (this *SingleArgument) markSet() {
+	this.isSet = true
+}
+
+func (this *ArgumentParser) expandTemplates() error {
+	for _, arg := range this.optArgs {
+		if err := this.expandArgument(arg, nil); err != nil {
+			src := this.argSource(arg)
+			if src.Kind == SourceFile {
+				return &ConfigError{File: src.Name, Line: src.Line, Key: arg.Token(), Err: err}
+			}
+			return &ConfigError{File: src.String(), Key: arg.Token(), Err: err}
+		}
+	}
+	return nil
+}
+
+// expandArgument sets the argument to the expanded values of its templates,
+// visiting are the arguments being expanded
+func (this *ArgumentParser) expandArgument(arg Argument, visiting []Argument) error {
+	values, ok := this.templates[arg]
+	if !ok {
+		return nil
+	}
+	visiting = append(visiting, arg)
+	for _, value := range values {
+		value, err := this.interpolate(arg, value, visiting)
+		if err != nil {
+			return err
+		}
+		if err := arg.SetValue(value); err != nil {
+			return err
+		}
+	}
+	delete(this.templates, arg)
+	return nil
+}
+
+// interpolate expands the references of a value of arg: ${NAME} is the
+// value of the option of the token or key NAME, or else of the environment
+// variable NAME.  ${NAME:-fallback} is the fallback if the value is empty or
+// not set.  "$${" is a literal "${".
+func (this *ArgumentParser) interpolate(arg Argument, value string, visiting []Argument) (string, error) {
+	if !hasReference(value) {
+		return value, nil
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "$${") {
+			buf.WriteString("${")
+			i += 3
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			buf.WriteByte(value[i])
+			i++
+			continue
+		}
+		end := referenceEnd(value, i+2)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference %q", redactString(arg, value[i:]))
+		}
+		str, err := this.resolveReference(arg, value[i+2:end], visiting)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(str)
+		i = end + 1
+	}
+	return buf.String(), nil
+}
+
+// referenceEnd returns the index of the "}" closing a reference starting at
+// start, the fallback may contain references
+func referenceEnd(value string, start int) int {
+	depth := 1
+	for i := start; i < len(value); i++ {
+		switch value[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveReference returns the value of a reference in a value of arg
+func (this *ArgumentParser) resolveReference(arg Argument, ref string, visiting []Argument) (string, error) {
+	name, fallback, hasFallback := ref, "", false
+	if pos := strings.Index(ref, ":-"); pos >= 0 {
+		name, fallback, hasFallback = ref[:pos], ref[pos+2:], true
+	}
+	var value string
+	var found bool
+	token := keyToToken(strings.Replace(name, ".", "-", -1))
+	if refArg, _, _ := this.findOptionalArgument(token, true); refArg != nil {
+		var err error
+		value, found, err = this.argumentReference(refArg, visiting)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		value, found = os.LookupEnv(name)
+	}
+	if hasFallback && len(value) == 0 {
+		return this.interpolate(arg, fallback, visiting)
+	}
+	if !found {
+		return "", &ReferenceError{Reference: name}
+	}
+	return value, nil
+}
+
+// argumentReference returns the value of a referenced argument, the value
+// set, or else the default value
+func (this *ArgumentParser) argumentReference(arg Argument, visiting []Argument) (string, bool, error) {
+	for i := range visiting {
+		if visiting[i] == arg {
+			cycle := make([]string, 0, len(visiting)-i+1)
+			for _, v := range visiting[i:] {
+				cycle = append(cycle, v.Token())
+			}
+			cycle = append(cycle, arg.Token())
+			return "", false, &ReferenceError{Reference: arg.Token(), Cycle: cycle}
+		}
+	}
+	if this.templates != nil {
+		if err := this.expandArgument(arg, visiting); err != nil {
+			return "", false, err
+		}
+	}
+	valArg, ok := arg.(interface {
+		getValue() reflect.Value
+	})
+	if !ok {
+		return "", false, nil
+	}
+	if arg.IsSet() {
+		return referenceString(valArg.getValue()), true, nil
+	}
+	defArg, ok := arg.(interface {
+		defaultTemplate() string
+		defaultValue() reflect.Value
+	})
+	if !ok {
+		return "", false, nil
+	}
+	if tmpl := defArg.defaultTemplate(); len(tmpl) > 0 {
+		value, err := this.interpolate(arg, tmpl, append(visiting, arg))
+		return value, err == nil, err
+	}
+	if defVal := defArg.defaultValue(); defVal.IsValid() {
+		return referenceString(defVal), true, nil
+	}
+	return "", false, nil
+}
+
+// referenceString formats the value of a referenced argument, values of
+// arrays and maps are separated by ","
+func referenceString(value reflect.Value) string {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = referenceString(value.Index(i))
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, value.Len())
+		for _, k := range value.MapKeys() {
+			items = append(items, fmt.Sprintf("%v=%s", k.Interface(), referenceString(value.MapIndex(k))))
+		}
+		sort.Strings(items)
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value.Interface())
+}
+
+func (this *SingleArgument) defaultTemplate() string {
+	return this.defTemplate
+}
+
+// defaultValue returns the default value, invalid if there is none
+func (this *SingleArgument) defaultValue() reflect.Value {
+	if !this.useDefault || len(this.defTemplate) > 0 {
+		return reflect.Value{}
+	}
+	return this.defValue
+}
+
+// expandDefaults expands the references of the default values of the
+// arguments not set
+func (this *ArgumentParser) expandDefaults() error {
+	errs := make([]error, 0)
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			defArg, ok := arg.(interface {
+				defaultTemplate() string
+				setDefaultString(str string) error
+			})
+			if !ok || len(defArg.defaultTemplate()) == 0 || arg.IsSet() {
+				continue
+			}
+			value, err := this.interpolate(arg, defArg.defaultTemplate(), []Argument{arg})
+			if err == nil {
+				err = defArg.setDefaultString(value)
+			}
+			if err != nil {
+				errs = append(errs, &ConfigError{File: string(SourceDefault), Key: arg.Token(), Err: err})
+			}
+		}
+	}
+	return joinErrors(errs)
+}
+
+func (this *SingleArgument) setDefaultString(str string) error {
+	value, err := gotypes.ParseValue(str, this.value.Type())
+	if err != nil {
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: this, Value: str, Err: err}
+	}
+	this.defValue = value
+	return nil
+}
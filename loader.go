@@ -0,0 +1,157 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+)
+
+// ConfigLayer is a source of argument values for Load, e.g. the defaults,
+// a config file, the environment or the command line
+type ConfigLayer interface {
+	load(parser *ArgumentParser, ec *errorCollector) error
+}
+
+type sDefaultsLayer struct{}
+
+// DefaultsLayer sets the arguments not yet set to their default values
+func DefaultsLayer() ConfigLayer {
+	return &sDefaultsLayer{}
+}
+
+func (self *sDefaultsLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	return parser.setDefaults()
+}
+
+type sFileLayer struct {
+	filepath string
+}
+
+// FileLayer sets the arguments from a config file, see ParseFile
+func FileLayer(filepath string) ConfigLayer {
+	return &sFileLayer{filepath: filepath}
+}
+
+func (self *sFileLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	return parser.parseFile(self.filepath)
+}
+
+type sConfigDirLayer struct {
+	dir string
+}
+
+// ConfigDirLayer sets the arguments from the config files in a drop-in
+// directory, see ParseConfigDir
+func ConfigDirLayer(dir string) ConfigLayer {
+	return &sConfigDirLayer{dir: dir}
+}
+
+func (self *sConfigDirLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	return parser.parseConfigDir(self.dir)
+}
+
+type sEnvLayer struct{}
+
+// EnvLayer sets the arguments bound to environment variables, see
+// SetEnvPrefix
+func EnvLayer() ConfigLayer {
+	return &sEnvLayer{}
+}
+
+func (self *sEnvLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	return ec.collectAll(parser.parseEnv())
+}
+
+type sArgsLayer struct {
+	args          []string
+	ignoreUnknown bool
+}
+
+// ArgsLayer sets the arguments from the command line, see ParseArgs2
+func ArgsLayer(args []string, ignoreUnknown bool) ConfigLayer {
+	return &sArgsLayer{args: args, ignoreUnknown: ignoreUnknown}
+}
+
+func (self *sArgsLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	return parser.parseArgs(self.args, self.ignoreUnknown, ec)
+}
+
+// Load resets the arguments and sets them from the layers, lowest
+// precedence first, e.g.
+//
+//	parser.Load(DefaultsLayer(), FileLayer("/etc/region.conf"),
+//	    FileLayer("/etc/region.d/local.conf"), EnvLayer(),
+//	    ArgsLayer(os.Args[1:], false))
+//
+// A layer overrides the values set by the layers before it.  Values of an
+// array argument are replaced as a whole, values of a map argument are
+// merged key by key.  The default value is always replaced.  The arguments
+// are validated after all layers are loaded.
+func (this *ArgumentParser) Load(layers ...ConfigLayer) error {
+	ec := newErrorCollector(this.failFast)
+	this.reset()
+	this.loadLayers = append([]ConfigLayer{}, layers...)
+	this.configFiles = nil
+	this.argLayers = make(map[Argument]int)
+	defer func() {
+		this.argLayers = nil
+	}()
+	var err error
+	this.loadLayer = 0
+	for _, layer := range layers {
+		// layers are numbered by a counter, a layer may parse several
+		// config files as layers of their own, see ParseConfigDir
+		this.loadLayer++
+		err = layer.load(this, ec)
+		if err != nil {
+			break
+		}
+	}
+	return this.finishParse(err, ec, false)
+}
+
+// overrideArgument reports whether a config source may set the argument.
+// Outside Load, arguments already set are kept.  In Load, the argument is
+// prepared to be overridden by the current layer, a -file companion
+// prepares its target.
+func (this *ArgumentParser) overrideArgument(arg Argument) bool {
+	if this.argLayers == nil {
+		return !arg.IsSet()
+	}
+	if fileArg, ok := arg.(*sFileArg); ok {
+		delete(this.sources, arg)
+		delete(this.templates, arg)
+		arg = fileArg.target
+	}
+	prev, ok := this.argLayers[arg]
+	if this.argSource(arg).Kind == SourceDefault || (ok && prev != this.loadLayer && arg.IsMulti() && !argIsMap(arg)) {
+		arg.Reset()
+		delete(this.templates, arg)
+	}
+	if !ok || prev != this.loadLayer {
+		delete(this.sources, arg)
+	}
+	this.argLayers[arg] = this.loadLayer
+	return true
+}
+
+func argIsMap(arg Argument) bool {
+	if valArg, ok := arg.(interface {
+		getValue() reflect.Value
+	}); ok {
+		return valueIsMap(valArg.getValue())
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	type opts struct {
+		Region   string   `default:"region0"`
+		Port     int      `default:"80"`
+		Timeout  int      `default:"10"`
+		Debug    bool     `env:"TEST_LOAD_DEBUG"`
+		Networks []string `default:"net0"`
+		Servers  []string
+		Labels   map[string]string `env:"TEST_LOAD_LABELS"`
+		User     string            `env:"TEST_LOAD_USER" required:"true"`
+	}
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf1 := writeTestFile(t, dir, "1.conf", `
+region = 'region1'
+port = 8080
+networks = ['net1', 'net2']
+servers = ['s1', 's2']
+labels = ['a=1', 'b=1']
+`)
+	conf2 := writeTestFile(t, dir, "2.conf", `
+port = 9090
+networks = ['net3']
+labels = ['b=2', 'c=2']
+`)
+	defer setEnvs(t, map[string]string{
+		"TEST_LOAD_DEBUG":  "true",
+		"TEST_LOAD_LABELS": "c=3,d=3",
+		"TEST_LOAD_USER":   "env-user",
+	})()
+
+	s := &opts{}
+	p := mustNewParser(t, s)
+	err = p.Load(DefaultsLayer(), FileLayer(conf1), FileLayer(conf2), EnvLayer(),
+		ArgsLayer([]string{"--servers", "s3", "--labels", "d=4", "--port", "22"}, false))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := opts{
+		Region:   "region1",
+		Port:     22,
+		Timeout:  10,
+		Debug:    true,
+		Networks: []string{"net3"},
+		Servers:  []string{"s3"},
+		Labels:   map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"},
+		User:     "env-user",
+	}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("want %#v, got %#v", want, *s)
+	}
+	wantSources := map[string]SourceKind{
+		"region":   SourceFile,
+		"port":     SourceCLI,
+		"timeout":  SourceDefault,
+		"debug":    SourceEnv,
+		"networks": SourceFile,
+		"servers":  SourceCLI,
+		"labels":   SourceCLI,
+		"user":     SourceEnv,
+	}
+	for token, kind := range wantSources {
+		if got := p.Source(token).Kind; got != kind {
+			t.Errorf("%s: want source %s, got %s", token, kind, got)
+		}
+	}
+	if src := p.Source("networks"); src.Name != conf2 {
+		t.Errorf("networks should come from %s, got %s", conf2, src)
+	}
+
+	t.Run("custom precedence", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		err := p.Load(ArgsLayer([]string{"--port", "22", "--region", "cli"}, false), FileLayer(conf2), EnvLayer())
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		if s.Port != 9090 || s.Region != "cli" || s.Timeout != 0 || !reflect.DeepEqual(s.Networks, []string{"net3"}) {
+			t.Errorf("wrong load result: %#v", s)
+		}
+	})
+	t.Run("validate", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		err := p.Load(DefaultsLayer(), FileLayer(conf1))
+		if _, ok := err.(*RequiredArgumentError); !ok {
+			t.Errorf("want RequiredArgumentError, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"reflect"
+)
+
+// RedactedValue replaces the values of secret arguments in the output of
+// the parser, i.e. help, written configs, value sources, error messages
+// and warnings
+const RedactedValue = "******"
+
+// IsSecret returns whether the argument is tagged secret or sensitive
+func (this *SingleArgument) IsSecret() bool {
+	return this.secret
+}
+
+// argIsSecret reports whether the values of the argument are masked in
+// the output of the parser
+func argIsSecret(arg Argument) bool {
+	if secretArg, ok := arg.(interface {
+		IsSecret() bool
+	}); ok {
+		return secretArg.IsSecret()
+	}
+	return false
+}
+
+// redactString masks a value of the argument if it is secret
+func redactString(arg Argument, value string) string {
+	if len(value) > 0 && arg != nil && argIsSecret(arg) {
+		return RedactedValue
+	}
+	return value
+}
+
+// redactValue masks a non-zero value of the argument if it is secret
+func redactValue(arg Argument, value reflect.Value) interface{} {
+	if !value.IsValid() || !value.CanInterface() {
+		return nil
+	}
+	if argIsSecret(arg) && !isZeroValue(value) {
+		return RedactedValue
+	}
+	return value.Interface()
+}
+
+func isZeroValue(value reflect.Value) bool {
+	return reflect.DeepEqual(value.Interface(), reflect.Zero(value.Type()).Interface())
+}
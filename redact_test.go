@@ -0,0 +1,134 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type redactTestOptions struct {
+	AdminPassword string `secret:"true"`
+	Pin           int    `sensitive:"true"`
+	Level         string `sensitive:"true" choices:"low|high"`
+	Token         string `sensitive:"true"`
+	Region        string
+}
+
+func TestRedact(t *testing.T) {
+	t.Run("sensitive", func(t *testing.T) {
+		p := mustNewParser(t, &redactTestOptions{})
+		for _, token := range []string{"admin-password", "pin", "level", "token"} {
+			arg, _, _ := p.findOptionalArgument(token, true)
+			if arg == nil || !argIsSecret(arg) {
+				t.Errorf("%s should be secret", token)
+			}
+		}
+		if arg, _, _ := p.findOptionalArgument("region", true); argIsSecret(arg) {
+			t.Errorf("region should not be secret")
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		cases := [][]string{
+			{"--pin", "s3cret"},
+			{"--level", "s3cret"},
+		}
+		for _, args := range cases {
+			err := mustNewParser(t, &redactTestOptions{}).ParseArgs(args, false)
+			if err == nil || strings.Contains(err.Error(), "s3cret") || !strings.Contains(err.Error(), RedactedValue) {
+				t.Errorf("%v: value should be masked, got %v", args, err)
+			}
+		}
+		err := mustNewParser(t, &redactTestOptions{}).ParseArgs([]string{"--region", "r1", "--level", "middle"}, false)
+		if err == nil || !strings.Contains(err.Error(), RedactedValue) {
+			t.Errorf("choice should be masked, got %v", err)
+		}
+		// the inline value of a misspelled option is left out
+		err = mustNewParser(t, &redactTestOptions{}).ParseArgs([]string{"--admin-passwrd=s3cret"}, false)
+		var unknownErr *UnknownArgumentError
+		if !errorAs(err, &unknownErr) || strings.Contains(err.Error(), "s3cret") || unknownErr.Input != "--admin-passwrd" ||
+			len(unknownErr.Suggestions) == 0 || unknownErr.Suggestions[0] != "--admin-password" {
+			t.Errorf("inline value should be left out, got %v", err)
+		}
+	})
+	t.Run("config errors", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "structarg")
+		if err != nil {
+			t.Fatalf("TempDir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		conf := writeTestFile(t, dir, "region.conf", "pin = 's3cret'\n")
+		p := mustNewParser(t, &redactTestOptions{})
+		p.SetStrictConfig(true)
+		err = p.ParseFile(conf)
+		if err == nil || strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("value should be masked, got %v", err)
+		}
+
+		// unterminated references in config files and environment variables
+		conf = writeTestFile(t, dir, "region.yaml", "admin_password: 's3cret${oops'\n")
+		err = mustNewParser(t, &redactTestOptions{}).ParseFile(conf)
+		if err == nil || strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "oops") {
+			t.Errorf("unterminated reference should be masked, got %v", err)
+		}
+		os.Setenv("REDACT_TEST_ADMIN_PASSWORD", "s3cret${oops")
+		defer os.Unsetenv("REDACT_TEST_ADMIN_PASSWORD")
+		p = mustNewParser(t, &redactTestOptions{})
+		p.SetEnvPrefix("REDACT_TEST_")
+		err = p.ParseArgs([]string{}, false)
+		if err == nil || strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "oops") {
+			t.Errorf("unterminated reference should be masked, got %v", err)
+		}
+		conf = writeTestFile(t, dir, "region2.yaml", "region: 'r1${oops'\n")
+		err = mustNewParser(t, &redactTestOptions{}).ParseFile(conf)
+		if err == nil || !strings.Contains(err.Error(), "oops") {
+			t.Errorf("unterminated reference of region should not be masked, got %v", err)
+		}
+	})
+	t.Run("output", func(t *testing.T) {
+		p := mustNewParser(t, &redactTestOptions{})
+		if err := p.ParseArgs([]string{"--admin-password", "s3cret", "--pin", "1234", "--region", "r1"}, false); err != nil {
+			t.Fatalf("ParseArgs: %v", err)
+		}
+		for _, src := range p.Sources() {
+			switch src.Argument.Token() {
+			case "admin-password", "pin":
+				if src.Value != RedactedValue {
+					t.Errorf("%s: want masked value, got %v", src.Argument.Token(), src.Value)
+				}
+			case "token":
+				if src.Value != "" {
+					t.Errorf("empty secret should not be masked, got %v", src.Value)
+				}
+			case "region":
+				if src.Value != "r1" {
+					t.Errorf("region: want r1, got %v", src.Value)
+				}
+			}
+		}
+		for _, format := range []ConfigFormat{ConfigFormatYAML, ConfigFormatJSON, ConfigFormatTOML, ConfigFormatTornado} {
+			buf := &bytes.Buffer{}
+			if err := p.WriteConfig(buf, format); err != nil {
+				t.Fatalf("WriteConfig: %v", err)
+			}
+			if out := buf.String(); strings.Contains(out, "s3cret") || strings.Contains(out, "1234") {
+				t.Errorf("%s: secrets should be masked\n%s", format, out)
+			}
+		}
+	})
+}
@@ -0,0 +1,487 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/utils"
+)
+
+// OptionChange is an option changed by Reload
+type OptionChange struct {
+	Token string
+	// Old and New are the values, RedactedValue for secret options
+	Old interface{}
+	New interface{}
+	// Reloadable is false for the options tagged reloadable:"false", the
+	// new value takes effect after a restart
+	Reloadable bool
+}
+
+// ConfigChange is the result of Reload
+type ConfigChange struct {
+	// Options is the new copy of the options struct
+	Options interface{}
+	Changes []OptionChange
+}
+
+// RequiresRestart reports whether an option not reloadable is changed
+func (c *ConfigChange) RequiresRestart() bool {
+	for i := range c.Changes {
+		if !c.Changes[i].Reloadable {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReloadable returns whether a change of the value by Reload takes effect
+// without a restart
+func (this *SingleArgument) IsReloadable() bool {
+	return !this.noReload
+}
+
+func argIsReloadable(arg Argument) bool {
+	if reloadArg, ok := arg.(interface {
+		IsReloadable() bool
+	}); ok {
+		return reloadArg.IsReloadable()
+	}
+	return true
+}
+
+// addReloadLayer records a config file or directory parsed outside Load
+func (this *ArgumentParser) addReloadLayer(path string, layer ConfigLayer) {
+	if this.argLayers != nil || utils.IsInStringArray(path, this.reloadPaths) {
+		return
+	}
+	this.reloadPaths = append(this.reloadPaths, path)
+	this.reloadLayers = append(this.reloadLayers, layer)
+}
+
+// addConfigFile records a config file or directory read, to be watched
+func (this *ArgumentParser) addConfigFile(path string) {
+	if !utils.IsInStringArray(path, this.configFiles) {
+		this.configFiles = append(this.configFiles, path)
+	}
+}
+
+// ConfigFiles returns the config files and directories read by ParseFile,
+// ParseConfigDir and Load, including the included files, and the files read
+// by the -file companions
+func (this *ArgumentParser) ConfigFiles() []string {
+	return append([]string{}, this.configFiles...)
+}
+
+type sCLIValuesLayer struct {
+	from *ArgumentParser
+}
+
+// load sets the values set on the command line of the parser from, the
+// command line is not parsed again as the sub commands are not known.  The
+// files of the -file companions are read again.
+func (self *sCLIValuesLayer) load(parser *ArgumentParser, ec *errorCollector) error {
+	for _, args := range [][]Argument{self.from.posArgs, self.from.optArgs} {
+		for _, fromArg := range args {
+			if path, ok := self.from.cliFiles[fromArg]; ok {
+				fileArg, ok := parser.sameArgument(self.from, fromArg).(*sFileArg)
+				if !ok || !parser.overrideArgument(fileArg) {
+					continue
+				}
+				if err := ec.collect(fileArg.SetValue(path), -1); err != nil {
+					return err
+				}
+				parser.setSource(fileArg, ValueSource{Kind: SourceCLI})
+				parser.setSource(fileArg.target, ValueSource{Kind: SourceCLI})
+				continue
+			}
+			value, ok := self.from.cliValues[fromArg]
+			if !ok {
+				continue
+			}
+			arg := parser.sameArgument(self.from, fromArg)
+			valArg, ok := arg.(interface {
+				setValueFrom(value reflect.Value)
+			})
+			if !ok || !parser.overrideArgument(arg) {
+				continue
+			}
+			valArg.setValueFrom(value)
+			parser.setSource(arg, ValueSource{Kind: SourceCLI})
+		}
+	}
+	return nil
+}
+
+// saveCLIValues copies the values set on the command line, the values may
+// be overridden by later layers of Load.  For the arguments set by the
+// -file companions, the files are kept instead of the values, so that
+// Reload reads the files again.
+func (this *ArgumentParser) saveCLIValues() {
+	if this.cliValues == nil {
+		this.cliValues = make(map[Argument]reflect.Value)
+	}
+	if this.cliFiles == nil {
+		this.cliFiles = make(map[Argument]string)
+	}
+	fromFile := make(map[Argument]bool)
+	for _, arg := range this.optArgs {
+		if fileArg, ok := arg.(*sFileArg); ok && len(fileArg.path) > 0 && this.argSource(arg).Kind == SourceCLI {
+			this.cliFiles[arg] = fileArg.path
+			fromFile[fileArg.target] = true
+		}
+	}
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			valArg, ok := arg.(interface {
+				getValue() reflect.Value
+			})
+			if ok && !fromFile[arg] && this.argSource(arg).Kind == SourceCLI {
+				this.cliValues[arg] = copyValue(valArg.getValue())
+			}
+		}
+	}
+}
+
+func (this *SingleArgument) setValueFrom(value reflect.Value) {
+	this.value.Set(copyValue(value))
+	this.isSet = true
+}
+
+// copyValue copies a value, arrays, maps and pointers are copied so that
+// the copy does not share the values
+func copyValue(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Slice:
+		if value.IsNil() {
+			return value
+		}
+		ret := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		reflect.Copy(ret, value)
+		return ret
+	case reflect.Map:
+		if value.IsNil() {
+			return value
+		}
+		ret := reflect.MakeMap(value.Type())
+		for _, k := range value.MapKeys() {
+			ret.SetMapIndex(k, value.MapIndex(k))
+		}
+		return ret
+	case reflect.Ptr:
+		if value.IsNil() {
+			return value
+		}
+		ret := reflect.New(value.Type().Elem())
+		ret.Elem().Set(copyValue(value.Elem()))
+		return ret
+	}
+	// not the addressable value of the field
+	ret := reflect.New(value.Type()).Elem()
+	ret.Set(value)
+	return ret
+}
+
+// sameArgument returns the argument of the parser matching the argument of
+// the other parser of the same options struct
+func (this *ArgumentParser) sameArgument(other *ArgumentParser, arg Argument) Argument {
+	if arg.IsPositional() {
+		for i := range other.posArgs {
+			if other.posArgs[i] == arg && i < len(this.posArgs) {
+				return this.posArgs[i]
+			}
+		}
+		return nil
+	}
+	match, _, _ := this.findOptionalArgument(arg.Token(), true)
+	return match
+}
+
+// reloadConfigLayers returns the layers to parse the config again.  The
+// files parsed first take precedence outside Load, so they come last.
+func (this *ArgumentParser) reloadConfigLayers() []ConfigLayer {
+	cli := &sCLIValuesLayer{from: this}
+	if this.loadLayers != nil {
+		layers := make([]ConfigLayer, len(this.loadLayers))
+		for i, layer := range this.loadLayers {
+			if _, ok := layer.(*sArgsLayer); ok {
+				layer = cli
+			}
+			layers[i] = layer
+		}
+		return layers
+	}
+	layers := []ConfigLayer{DefaultsLayer()}
+	for i := len(this.reloadLayers) - 1; i >= 0; i-- {
+		layers = append(layers, this.reloadLayers[i])
+	}
+	return append(layers, EnvLayer(), cli)
+}
+
+// Reload parses the config files, directories and environment variables
+// again into a new copy of the options struct, keeping the values set on
+// the command line.  If the new options are valid, the changes of the
+// reloadable options are applied to the options struct of the parser.
+// Otherwise the error is returned and the options struct is not changed.
+//
+// The options struct is changed in place by the calling goroutine, the
+// caller synchronizes the access of other goroutines.
+func (this *ArgumentParser) Reload() (*ConfigChange, error) {
+	change, _, apply, err := this.reload()
+	if err != nil {
+		return nil, err
+	}
+	apply()
+	return change, nil
+}
+
+// reload parses the config again into a new copy of the options struct.
+// The changes of the reloadable options are applied to the options struct
+// of the parser by apply, files are the config files read.
+func (this *ArgumentParser) reload() (*ConfigChange, []string, func(), error) {
+	targetType := reflect.TypeOf(this.target)
+	if targetType.Kind() != reflect.Ptr {
+		return nil, nil, nil, fmt.Errorf("options %s is not a pointer", targetType)
+	}
+	target := reflect.New(targetType.Elem()).Interface()
+	fresh, err := newArgumentParser(target, this.prog, this.description, this.epilog)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fresh.inheritSettings(this)
+	if err := fresh.Load(this.reloadConfigLayers()...); err != nil {
+		return nil, nil, nil, err
+	}
+	change := &ConfigChange{Options: target}
+	applies := make([]func(), 0)
+	for _, arg := range this.optArgs {
+		freshArg := fresh.sameArgument(this, arg)
+		valArg, ok := arg.(interface {
+			getValue() reflect.Value
+		})
+		freshValArg, freshOk := freshArg.(interface {
+			getValue() reflect.Value
+		})
+		if !ok || !freshOk || arg.IsSubcommand() {
+			continue
+		}
+		value, freshValue := valArg.getValue(), freshValArg.getValue()
+		if reflect.DeepEqual(value.Interface(), freshValue.Interface()) {
+			continue
+		}
+		reloadable := argIsReloadable(arg)
+		change.Changes = append(change.Changes, OptionChange{
+			Token:      arg.Token(),
+			Old:        redactValue(arg, value),
+			New:        redactValue(arg, freshValue),
+			Reloadable: reloadable,
+		})
+		if reloadable {
+			arg, src := arg, fresh.argSource(freshArg)
+			applies = append(applies, func() {
+				value.Set(copyValue(freshValue))
+				this.setSource(arg, src)
+			})
+		}
+	}
+	apply := func() {
+		for _, fn := range applies {
+			fn()
+		}
+		this.configFiles = fresh.configFiles
+	}
+	return change, fresh.configFiles, apply, nil
+}
+
+type fileStamp struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func statFiles(files []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			stamps[file] = fileStamp{exists: true, size: info.Size(), modTime: info.ModTime()}
+		} else {
+			stamps[file] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+// ConfigWatcher polls the config files of a parser and reloads the options
+// when a file changes, see Reload.  The options struct of the parser is
+// changed only by the function set by SetApplyFunc.
+type ConfigWatcher struct {
+	parser   *ArgumentParser
+	interval time.Duration
+
+	lock      sync.Mutex
+	files     []string
+	stamps    map[string]fileStamp
+	applyFunc func(apply func())
+	onChange  []func(change *ConfigChange)
+	onError   []func(err error)
+	stop      chan struct{}
+}
+
+// DefaultWatchInterval is the polling interval of a ConfigWatcher created
+// with an interval not positive
+const DefaultWatchInterval = 5 * time.Second
+
+// NewConfigWatcher returns a watcher polling the config files read by the
+// parser every interval, DefaultWatchInterval if the interval is not
+// positive.  The watcher is started by Start.
+func (this *ArgumentParser) NewConfigWatcher(interval time.Duration) *ConfigWatcher {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	return &ConfigWatcher{
+		parser:   this,
+		interval: interval,
+		files:    this.ConfigFiles(),
+		stamps:   statFiles(this.configFiles),
+	}
+}
+
+// SetApplyFunc sets the function applying the changes of a reload to the
+// options struct of the parser, e.g. holding the lock of the options:
+//
+//	watcher.SetApplyFunc(func(apply func()) {
+//		lock.Lock()
+//		defer lock.Unlock()
+//		apply()
+//	})
+//
+// Without it, the options struct of the parser is left untouched and the
+// callbacks use the new copy in ConfigChange.Options.  The changes are
+// always relative to the options struct of the parser.
+func (this *ConfigWatcher) SetApplyFunc(applyFunc func(apply func())) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.applyFunc = applyFunc
+}
+
+// OnChange registers a callback called with the changes of a reload, the
+// callback is not called if no option is changed
+func (this *ConfigWatcher) OnChange(callback func(change *ConfigChange)) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.onChange = append(this.onChange, callback)
+}
+
+// OnError registers a callback called with the errors of a reload, e.g.
+// syntax errors or invalid options.  Errors are logged if there is no
+// callback.
+func (this *ConfigWatcher) OnError(callback func(err error)) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.onError = append(this.onError, callback)
+}
+
+// Start starts polling in a goroutine
+func (this *ConfigWatcher) Start() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.stop != nil {
+		return
+	}
+	this.stop = make(chan struct{})
+	go this.run(this.stop)
+}
+
+// Stop stops polling
+func (this *ConfigWatcher) Stop() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+}
+
+func (this *ConfigWatcher) run(stop chan struct{}) {
+	ticker := time.NewTicker(this.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			this.Check()
+		}
+	}
+}
+
+// Check reloads the options if a config file is changed since the last
+// check, the changes are applied and the callbacks are called before it
+// returns
+func (this *ConfigWatcher) Check() (*ConfigChange, error) {
+	change, apply, onChange, onError, err := this.reload()
+	if err != nil {
+		if len(onError) == 0 {
+			log.Errorf("Reload config: %v", err)
+		}
+		for _, callback := range onError {
+			callback(err)
+		}
+		return nil, err
+	}
+	if apply != nil {
+		apply()
+	}
+	if change != nil && len(change.Changes) > 0 {
+		for _, callback := range onChange {
+			callback(change)
+		}
+	}
+	return change, nil
+}
+
+// reload reloads the options if a config file is changed, the function
+// applying the changes and the callbacks are returned to be called without
+// the lock
+func (this *ConfigWatcher) reload() (*ConfigChange, func(), []func(change *ConfigChange), []func(err error), error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stamps := statFiles(this.files)
+	if reflect.DeepEqual(stamps, this.stamps) {
+		return nil, nil, nil, nil, nil
+	}
+	// a broken file is reported once until it is changed again
+	this.stamps = stamps
+	change, files, apply, err := this.parser.reload()
+	if err != nil {
+		return nil, nil, this.onChange, this.onError, err
+	}
+	// the included files may be changed
+	this.files = files
+	this.stamps = statFiles(files)
+	var applyChange func()
+	if applyFunc := this.applyFunc; applyFunc != nil {
+		applyChange = func() {
+			applyFunc(apply)
+		}
+	}
+	return change, applyChange, this.onChange, this.onError, nil
+}
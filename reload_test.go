@@ -0,0 +1,346 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type reloadTestOptions struct {
+	Port          int    `default:"8080" reloadable:"false"`
+	LogLevel      string `default:"info" choices:"debug|info|warn"`
+	Workers       int
+	Labels        map[string]string
+	AdminPassword string `secret:"true"`
+}
+
+// rewriteTestFile writes the file with a new modification time, so that
+// the change is seen by the watcher
+func rewriteTestFile(t *testing.T, path, content string, age int) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	mtime := time.Now().Add(time.Duration(age) * time.Minute)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	base := writeTestFile(t, dir, "base.conf", "workers = 2\nadmin_password = 'pw1'\n")
+	site := writeTestFile(t, dir, "site.conf", "include = 'base.conf'\nlabels = ['a=1']\n")
+
+	s := &reloadTestOptions{}
+	p := mustNewParser(t, s)
+	// invalid values are returned instead of logged
+	p.SetStrictConfig(true)
+	if err := p.ParseArgs([]string{"--workers", "4"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if err := p.ParseFile(site); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	p.SetDefault()
+	if files := p.ConfigFiles(); !reflect.DeepEqual(files, []string{site, base}) {
+		t.Errorf("want config files %v, got %v", []string{site, base}, files)
+	}
+
+	w := p.NewConfigWatcher(time.Hour)
+	w.SetApplyFunc(func(apply func()) {
+		apply()
+	})
+	changes := make([]*ConfigChange, 0)
+	w.OnChange(func(change *ConfigChange) {
+		changes = append(changes, change)
+	})
+	errs := make([]error, 0)
+	w.OnError(func(err error) {
+		errs = append(errs, err)
+	})
+	if change, err := w.Check(); change != nil || err != nil {
+		t.Fatalf("unexpected reload %#v %v", change, err)
+	}
+
+	rewriteTestFile(t, base, "workers = 8\nport = 9090\nlog_level = 'debug'\nadmin_password = 'pw2'\n", 1)
+	change, err := w.Check()
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	wantChanges := []OptionChange{
+		{Token: "port", Old: 8080, New: 9090, Reloadable: false},
+		{Token: "log-level", Old: "info", New: "debug", Reloadable: true},
+		{Token: "admin-password", Old: RedactedValue, New: RedactedValue, Reloadable: true},
+	}
+	if len(changes) != 1 || changes[0] != change || !sameChanges(change.Changes, wantChanges) {
+		t.Fatalf("want changes %#v, got %#v", wantChanges, change)
+	}
+	if !change.RequiresRestart() {
+		t.Errorf("port change should require restart")
+	}
+	want := reloadTestOptions{
+		Port:          8080,
+		LogLevel:      "debug",
+		Workers:       4,
+		Labels:        map[string]string{"a": "1"},
+		AdminPassword: "pw2",
+	}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("want %#v, got %#v", want, *s)
+	}
+	if fresh := change.Options.(*reloadTestOptions); fresh.Port != 9090 || fresh.Workers != 4 {
+		t.Errorf("wrong new options %#v", fresh)
+	}
+	if src := p.Source("log-level"); src.Name != base || src.Line != 3 {
+		t.Errorf("log-level should come from %s:3, got %s", base, src)
+	}
+
+	rewriteTestFile(t, base, "log_level = 'verbose'\n", 2)
+	if _, err := w.Check(); err == nil || len(errs) != 1 {
+		t.Fatalf("want invalid choice error, got %v", err)
+	}
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("options should not change on failure, got %#v", *s)
+	}
+	if change, err := w.Check(); change != nil || err != nil {
+		t.Errorf("broken file should be reported once, got %#v %v", change, err)
+	}
+
+	// the file included is changed
+	other := writeTestFile(t, dir, "other.conf", "workers = 1\n")
+	rewriteTestFile(t, site, "include = 'other.conf'\n", 3)
+	if _, err := w.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if files := p.ConfigFiles(); !reflect.DeepEqual(files, []string{site, other}) {
+		t.Errorf("want config files %v, got %v", []string{site, other}, files)
+	}
+	if s.LogLevel != "info" || s.Labels != nil || s.AdminPassword != "" {
+		t.Errorf("removed values should be reset, got %#v", *s)
+	}
+}
+
+func sameChanges(a, b []OptionChange) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, ca := range a {
+		found := false
+		for _, cb := range b {
+			if reflect.DeepEqual(ca, cb) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReloadLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeTestFile(t, dir, "region.yaml", "log_level: warn\nworkers: 2\n")
+
+	s := &reloadTestOptions{}
+	p := mustNewParser(t, s)
+	err = p.Load(DefaultsLayer(), ArgsLayer([]string{"--log-level", "debug"}, false), FileLayer(conf))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if s.LogLevel != "warn" {
+		t.Fatalf("file should override cli, got %#v", s)
+	}
+
+	w := p.NewConfigWatcher(10 * time.Millisecond)
+	done := make(chan *ConfigChange, 1)
+	w.OnChange(func(change *ConfigChange) {
+		done <- change
+	})
+	w.Start()
+	defer w.Stop()
+	rewriteTestFile(t, conf, "workers: 3\n", 1)
+	select {
+	case change := <-done:
+		wantChanges := []OptionChange{
+			{Token: "log-level", Old: "warn", New: "debug", Reloadable: true},
+			{Token: "workers", Old: 2, New: 3, Reloadable: true},
+		}
+		if !sameChanges(change.Changes, wantChanges) {
+			t.Errorf("want changes %#v, got %#v", wantChanges, change.Changes)
+		}
+		// the options are not changed without an apply func
+		if fresh := change.Options.(*reloadTestOptions); fresh.LogLevel != "debug" || fresh.Workers != 3 {
+			t.Errorf("wrong new options %#v", fresh)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no reload")
+	}
+	w.Stop()
+	if s.LogLevel != "warn" || s.Workers != 2 {
+		t.Errorf("options should not be changed by the watcher, got %#v", *s)
+	}
+}
+
+// TestConfigWatcherApply reads the options while the watcher reloads, run
+// with -race
+func TestConfigWatcherApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeTestFile(t, dir, "region.conf", "workers = 1\n")
+
+	s := &reloadTestOptions{}
+	p := mustNewParser(t, s)
+	if err := p.ParseFile(conf); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	var lock sync.RWMutex
+	w := p.NewConfigWatcher(time.Millisecond)
+	w.SetApplyFunc(func(apply func()) {
+		lock.Lock()
+		defer lock.Unlock()
+		apply()
+	})
+	done := make(chan struct{})
+	var once sync.Once
+	w.OnChange(func(change *ConfigChange) {
+		if change.Options.(*reloadTestOptions).Workers == 5 {
+			once.Do(func() {
+				close(done)
+			})
+		}
+	})
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			lock.RLock()
+			_ = fmt.Sprint(s.Workers, s.Labels)
+			lock.RUnlock()
+			time.Sleep(time.Microsecond)
+		}
+	}()
+	w.Start()
+	for i := 2; i <= 5; i++ {
+		rewriteTestFile(t, conf, fmt.Sprintf("workers = %d\nlabels = ['a=%d']\n", i, i), i)
+		time.Sleep(5 * time.Millisecond)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("no reload")
+	}
+	w.Stop()
+	close(stop)
+	wg.Wait()
+	lock.RLock()
+	defer lock.RUnlock()
+	if s.Workers != 5 || !reflect.DeepEqual(s.Labels, map[string]string{"a": "5"}) {
+		t.Errorf("changes should be applied, got %#v", *s)
+	}
+}
+
+func TestReloadFileArguments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	pw := writeTestFile(t, dir, "pw", "pw1\n")
+	dbpw := writeTestFile(t, dir, "dbpw", "db1\n")
+	conf := writeTestFile(t, dir, "region.conf", "db_password_file = '"+dbpw+"'\nworkers = 2\n")
+
+	s := &struct {
+		AdminPassword string `secret:"true"`
+		DbPassword    string `secret:"true"`
+		Workers       int
+	}{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--admin-password-file", pw}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if err := p.ParseFile(conf); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	if files := p.ConfigFiles(); !reflect.DeepEqual(files, []string{pw, conf, dbpw}) {
+		t.Errorf("want config files %v, got %v", []string{pw, conf, dbpw}, files)
+	}
+
+	w := p.NewConfigWatcher(time.Hour)
+	w.SetApplyFunc(func(apply func()) {
+		apply()
+	})
+	// the rotated secrets are read again
+	rewriteTestFile(t, pw, "pw2\n", 1)
+	if _, err := w.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if s.AdminPassword != "pw2" || s.DbPassword != "db1" {
+		t.Errorf("admin password should be read again, got %#v", *s)
+	}
+	if src := p.Source("admin-password"); src.Kind != SourceCLI {
+		t.Errorf("admin-password should come from cli, got %s", src)
+	}
+	rewriteTestFile(t, dbpw, "db2\n", 2)
+	if _, err := w.Check(); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if s.AdminPassword != "pw2" || s.DbPassword != "db2" || s.Workers != 2 {
+		t.Errorf("db password should be read again, got %#v", *s)
+	}
+}
+
+func TestConfigWatcherInterval(t *testing.T) {
+	p := mustNewParser(t, &reloadTestOptions{})
+	for _, interval := range []time.Duration{0, -time.Second} {
+		w := p.NewConfigWatcher(interval)
+		if w.interval != DefaultWatchInterval {
+			t.Errorf("interval %s: want %s, got %s", interval, DefaultWatchInterval, w.interval)
+		}
+		// the polling does not panic
+		stop := make(chan struct{})
+		close(stop)
+		w.run(stop)
+	}
+	if w := p.NewConfigWatcher(time.Minute); w.interval != time.Minute {
+		t.Errorf("want interval %s, got %s", time.Minute, w.interval)
+	}
+}
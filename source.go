@@ -0,0 +1,221 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type SourceKind string
+
+const (
+	SourceUnset   = SourceKind("unset")
+	SourceCLI     = SourceKind("cli")
+	SourceEnv     = SourceKind("env")
+	SourceFile    = SourceKind("file")
+	SourceDefault = SourceKind("default")
+)
+
+// ValueSource describes where the value of an argument came from
+type ValueSource struct {
+	Kind SourceKind
+	// Name is the environment variable name or the config file path
+	Name string
+	// Line is the line number in the config file, 0 if unknown
+	Line int
+}
+
+func (s ValueSource) String() string {
+	switch s.Kind {
+	case SourceEnv:
+		return fmt.Sprintf("env %s", s.Name)
+	case SourceFile:
+		if s.Line > 0 {
+			return fmt.Sprintf("file %s:%d", s.Name, s.Line)
+		}
+		return fmt.Sprintf("file %s", s.Name)
+	case "":
+		return string(SourceUnset)
+	}
+	return string(s.Kind)
+}
+
+// ArgumentSource is an argument with its effective value and the source of
+// the value, the value of a secret argument is RedactedValue unless empty
+type ArgumentSource struct {
+	Argument Argument
+	Value    interface{}
+	Source   ValueSource
+}
+
+func (this *SingleArgument) getValue() reflect.Value {
+	return this.value
+}
+
+func (this *SingleArgument) hasDefault() bool {
+	return this.useDefault
+}
+
+func (this *ArgumentParser) setSource(arg Argument, src ValueSource) {
+	if this.sources == nil {
+		this.sources = make(map[Argument]ValueSource)
+	}
+	this.sources[arg] = src
+}
+
+// setSources records the source of the arguments that are set but have
+// no source yet, or only the default
+func (this *ArgumentParser) setSources(src ValueSource) {
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if old, ok := this.sources[arg]; (!ok || old.Kind == SourceDefault) && arg.IsSet() {
+				this.setSource(arg, src)
+			}
+		}
+	}
+}
+
+// Source returns the source of the value of the argument with the given
+// token, SourceUnset if the argument is not set or does not exist
+func (this *ArgumentParser) Source(token string) ValueSource {
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			if arg.Token() == token {
+				return this.argSource(arg)
+			}
+		}
+	}
+	return ValueSource{Kind: SourceUnset}
+}
+
+func (this *ArgumentParser) argSource(arg Argument) ValueSource {
+	if src, ok := this.sources[arg]; ok {
+		return src
+	}
+	return ValueSource{Kind: SourceUnset}
+}
+
+// Sources returns the values and their sources of all arguments of the
+// parser, positional arguments first, e.g. to log the effective
+// configuration.  Arguments of the sub command are reported by the sub
+// parser.
+func (this *ArgumentParser) Sources() []ArgumentSource {
+	ret := make([]ArgumentSource, 0, len(this.posArgs)+len(this.optArgs))
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			valArg, ok := arg.(interface {
+				getValue() reflect.Value
+			})
+			if !ok {
+				// pseudo arguments, e.g. --help and the -file
+				// companions
+				continue
+			}
+			ret = append(ret, ArgumentSource{
+				Argument: arg,
+				Value:    redactValue(arg, valArg.getValue()),
+				Source:   this.argSource(arg),
+			})
+		}
+	}
+	return ret
+}
+
+// configSource is a config file or in-memory document being parsed, for
+// the positions of sources, errors and warnings
+type configSource struct {
+	name    string
+	content string
+}
+
+func (src *configSource) position(line int) string {
+	return configPosition(src.name, line)
+}
+
+func (src *configSource) error(key string, err error) error {
+	if _, ok := err.(*ConfigError); ok {
+		return err
+	}
+	return &ConfigError{File: src.name, Line: src.keyLine(key), Key: key, Err: err}
+}
+
+var (
+	yamlErrorLineRegexp   = regexp.MustCompile(`line (\d+)`)
+	jsonErrorOffsetRegexp = regexp.MustCompile(`JSON error .* at (\d+):`)
+)
+
+// syntaxError returns the YAML or JSON syntax error with the position
+// found in the message of the error
+func (src *configSource) syntaxError(err error) error {
+	cerr := &ConfigError{File: src.name, Err: err}
+	if m := jsonErrorOffsetRegexp.FindStringSubmatch(err.Error()); m != nil {
+		offset, _ := strconv.Atoi(m[1])
+		if offset > len(src.content) {
+			offset = len(src.content)
+		}
+		cerr.Line = strings.Count(src.content[:offset], "\n") + 1
+		cerr.Column = offset - strings.LastIndexByte(src.content[:offset], '\n')
+	} else if m := yamlErrorLineRegexp.FindStringSubmatch(err.Error()); m != nil {
+		cerr.Line, _ = strconv.Atoi(m[1])
+	}
+	return cerr
+}
+
+// keyLine approximates the line of a key of a YAML or JSON document, keys
+// of nested objects are joined by ".", 0 if not found
+func (src *configSource) keyLine(key string) int {
+	if len(src.content) == 0 || len(key) == 0 {
+		return 0
+	}
+	lines := strings.Split(src.content, "\n")
+	start := 0
+	for _, part := range strings.Split(key, ".") {
+		found := false
+		for i := start; i < len(lines); i++ {
+			if lineHasKey(lines[i], part) {
+				// members of an object follow the key, or are on the same
+				// line for inline objects
+				start = i
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0
+		}
+	}
+	return start + 1
+}
+
+func lineHasKey(line, key string) bool {
+	isKeyEnd := func(rest string) bool {
+		return strings.HasPrefix(strings.TrimLeft(rest, " \t"), ":")
+	}
+	trimmed := strings.TrimLeft(line, " \t-")
+	if strings.HasPrefix(trimmed, key) && isKeyEnd(trimmed[len(key):]) {
+		return true
+	}
+	for _, quote := range []string{`"`, `'`} {
+		quoted := quote + key + quote
+		if pos := strings.Index(line, quoted); pos >= 0 && isKeyEnd(line[pos+len(quoted):]) {
+			return true
+		}
+	}
+	return false
+}
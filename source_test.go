@@ -0,0 +1,99 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSources(t *testing.T) {
+	type opts struct {
+		Region        string `default:"region0"`
+		SqlConnection string
+		AuthURL       string `env:"TEST_SOURCE_AUTH_URL"`
+		Port          int    `default:"8080"`
+		Debug         bool
+		Unset         string
+		Name          string `positional:"true"`
+	}
+	defer setEnvs(t, map[string]string{"TEST_SOURCE_AUTH_URL": "http://127.0.0.1:5000/v3"})()
+
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := filepath.Join(dir, "region.conf")
+	content := "# comment\nsql_connection = 'mysql://localhost'\n\nport = 9090\nauth_url = 'http://file'\n"
+	if err := ioutil.WriteFile(conf, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	s := &opts{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--debug", "name0"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if err := p.ParseTornadoFile(conf); err != nil {
+		t.Fatalf("ParseTornadoFile: %v", err)
+	}
+	p.SetDefault()
+
+	want := map[string]string{
+		"name":           "cli",
+		"region":         "default",
+		"sql-connection": "file " + conf + ":2",
+		"auth-url":       "env TEST_SOURCE_AUTH_URL",
+		"port":           "file " + conf + ":4",
+		"debug":          "cli",
+		"unset":          "unset",
+	}
+	sources := p.Sources()
+	if len(sources) != len(want) {
+		t.Fatalf("want %d sources, got %d", len(want), len(sources))
+	}
+	if sources[0].Argument.Token() != "name" || sources[0].Value != "name0" {
+		t.Errorf("positional argument should come first: %#v", sources[0])
+	}
+	for _, src := range sources {
+		token := src.Argument.Token()
+		if got := src.Source.String(); got != want[token] {
+			t.Errorf("%s: want source %q, got %q", token, want[token], got)
+		}
+		if got := p.Source(token); got != src.Source {
+			t.Errorf("%s: Source() %v != Sources() %v", token, got, src.Source)
+		}
+	}
+	if s.Port != 9090 || s.Region != "region0" || s.AuthURL != "http://127.0.0.1:5000/v3" {
+		t.Errorf("wrong parse result: %#v", s)
+	}
+	if got := p.Source("not-exist").Kind; got != SourceUnset {
+		t.Errorf("want unset for missing argument, got %s", got)
+	}
+
+	// sources are reset by the next parse
+	if err := p.ParseArgs([]string{"--port", "22", "name1"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	if got := p.Source("sql-connection").Kind; got != SourceUnset {
+		t.Errorf("want unset after reparse, got %s", got)
+	}
+	if got := p.Source("port").Kind; got != SourceCLI {
+		t.Errorf("want cli, got %s", got)
+	}
+}
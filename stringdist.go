@@ -93,6 +93,19 @@ func FindSimilar(niddle string, stack []string, maxDist int, minRate float64) []
 	return result
 }
 
+// maximal number of "did you mean" suggestions
+const maxSuggestions = 3
+
+// suggestSimilar returns at most maxSuggestions strings in stack that are
+// similar to niddle, the most similar one first
+func suggestSimilar(niddle string, stack []string) []string {
+	cands := FindSimilar(niddle, stack, -1, 0.5)
+	if len(cands) > maxSuggestions {
+		cands = cands[:maxSuggestions]
+	}
+	return cands
+}
+
 func ChoicesString(choices []string) string {
 	if len(choices) == 0 {
 		return ""
@@ -21,7 +21,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -66,17 +68,29 @@ type SingleArgument struct {
 	aliasToken string
 	shortToken string
 	negaToken  string
+	envName    string
 	metavar    string
-	positional bool
-	required   bool
-	help       string
-	choices    []string
-	useDefault bool
-	defValue   reflect.Value
-	value      reflect.Value
-	ovalue     reflect.Value
-	isSet      bool
-	parser     *ArgumentParser
+	secret     bool
+	// the value can be read from a file by the <token>-file companion
+	fromFile bool
+	// changes of the value take effect after a restart, see Reload
+	noReload bool
+	// default value with references, expanded by SetDefault
+	defTemplate string
+	// the environment variables of a legacy default, e.g. AUTH_URL of
+	// "$AUTH_URL|http://localhost", and the literal default after them
+	defEnvNames []string
+	defLiteral  string
+	positional  bool
+	required    bool
+	help        string
+	choices     []string
+	useDefault  bool
+	defValue    reflect.Value
+	value       reflect.Value
+	ovalue      reflect.Value
+	isSet       bool
+	parser      *ArgumentParser
 }
 
 type MultiArgument struct {
@@ -88,11 +102,25 @@ type MultiArgument struct {
 type SubcommandArgumentData struct {
 	parser   *ArgumentParser
 	callback reflect.Value
+	category string
 }
 
+// SubcommandOrder is the order of subcommands in the help
+type SubcommandOrder int
+
+const (
+	// SubcommandOrderRegistration lists subcommands and categories in the
+	// order they are added
+	SubcommandOrderRegistration SubcommandOrder = iota
+	// SubcommandOrderAlphabetical lists subcommands and categories sorted
+	// by name
+	SubcommandOrderAlphabetical
+)
+
 type SubcommandArgument struct {
 	SingleArgument
 	subcommands map[string]SubcommandArgumentData
+	order       SubcommandOrder
 }
 
 type ArgumentParser struct {
@@ -103,6 +131,47 @@ type ArgumentParser struct {
 	help        bool
 	optArgs     []Argument
 	posArgs     []Argument
+
+	remainingArgs []string
+
+	// disallow abbreviated tokens, i.e. prefix matching
+	noAbbrev bool
+	// stop at the first failure instead of collecting all of them
+	failFast bool
+	// reject unknown keys and invalid values of config files
+	strictConfig bool
+	// prefix of environment variable names derived from tokens
+	envPrefix string
+	// separator of array and map values in environment variables
+	envSeparator string
+	// width of the usage and help, 0 to detect
+	helpWidth int
+	// details not shown in the help of the arguments
+	noHelpAnnotations HelpAnnotation
+	// renders the usage and help, DefaultHelpFormatter if nil
+	helpFormatter HelpFormatter
+	// where the values of the arguments came from
+	sources map[Argument]ValueSource
+	// the layer setting each argument during Load, nil outside Load
+	argLayers map[Argument]int
+	loadLayer int
+	// absolute paths of the config files being parsed with their includes
+	including []string
+	// values with references of the config source being parsed, nil
+	// outside a config source
+	templates map[Argument][]string
+	// the layers of the last Load, or the files and directories parsed by
+	// ParseFile and ParseConfigDir otherwise, to be parsed again by Reload
+	loadLayers   []ConfigLayer
+	reloadLayers []ConfigLayer
+	reloadPaths  []string
+	// the config files and directories read, and the files read by the
+	// -file companions, watched by ConfigWatcher
+	configFiles []string
+	// copies of the values set on the command line, and the files of the
+	// -file companions set on the command line, for Reload
+	cliValues map[Argument]reflect.Value
+	cliFiles  map[Argument]string
 }
 
 type sHelpArg struct {
@@ -182,7 +251,7 @@ func (self *sHelpArg) IsSet() bool {
 
 func newArgumentParser(target interface{}, prog, desc, epilog string) (*ArgumentParser, error) {
 	parser := ArgumentParser{prog: prog, description: desc,
-		epilog: epilog, target: target}
+		epilog: epilog, target: target, envSeparator: ","}
 	targetValue := reflect.ValueOf(target)
 	if targetValue.Kind() != reflect.Ptr {
 		return nil, fmt.Errorf("target must be a pointer")
@@ -192,6 +261,10 @@ func newArgumentParser(target interface{}, prog, desc, epilog string) (*Argument
 	if e != nil {
 		return nil, e
 	}
+	e = parser.addFileArguments()
+	if e != nil {
+		return nil, e
+	}
 	// always add a help argument --help
 	helpArg := &sHelpArg{}
 	parser.AddArgument(helpArg)
@@ -224,7 +297,10 @@ const (
 	TAG_TOKEN = "token"
 	/*
 	   short form of command-line token, e.g. short-token:"u"
-	   the command-line argument will be "-u http://127.0.0.1:3306"
+	   the command-line argument will be "-u http://127.0.0.1:3306",
+	   "-uhttp://127.0.0.1:3306" or "-u=http://127.0.0.1:3306".
+	   single-character short tokens of boolean arguments can be
+	   combined, e.g. "-vdf" is the same as "-v -d -f"
 	   the tag is optional
 	*/
 	TAG_SHORT_TOKEN = "short-token"
@@ -235,6 +311,8 @@ const (
 	TAG_METAVAR = "metavar"
 	/*
 	   The default value of the argument.
+	   The value may contain references expanded when the default is set,
+	   e.g. default:"http://${controller_host}:${PORT:-80}"
 	   the tag is optional
 	*/
 	TAG_DEFAULT = "default"
@@ -283,6 +361,46 @@ const (
 	   Token for ignore
 	*/
 	TAG_IGNORE = "ignore"
+	/*
+	   Environment variable of the optional argument, e.g. env:"OS_AUTH_URL"
+	   the value of the environment variable is used if the argument is not
+	   set on the command line.  Values of array and map arguments are
+	   separated by ",", e.g. OS_NETWORKS="net1,net2", LABELS="k1=v1,k2=v2"
+	   the tag is optional.
+	   if the tag is missing and the parser has an environment variable
+	   prefix, the name is derived from the token, e.g. prefix "REGION_"
+	   and token "sql-connection" gives "REGION_SQL_CONNECTION"
+	*/
+	TAG_ENV = "env"
+	/*
+	   A boolean value declaring whether the argument is a secret, e.g. a
+	   password.  The values of secret arguments are masked in the output
+	   of the parser, i.e. help, written configs, value sources, error
+	   messages and warnings.
+	   the tag is optional, the default value is false
+	*/
+	TAG_SECRET = "secret"
+	/*
+	   Alias of the secret tag
+	*/
+	TAG_SENSITIVE = "sensitive"
+	/*
+	   A boolean value declaring whether the value of the optional argument
+	   can be read from a file, e.g. "--admin-password-file /run/secrets/pw",
+	   the config key "admin_password_file" or the environment variable
+	   of the argument with the suffix "_FILE".  Trailing newlines are
+	   trimmed, each line is a value of array and map arguments.
+	   the tag is optional, the default value is the value of the secret tag
+	*/
+	TAG_FILE = "file"
+	/*
+	   A boolean value declaring whether a change of the value by Reload
+	   takes effect without a restart, e.g. reloadable:"false" for the
+	   listen port.  Changes of arguments not reloadable are reported but
+	   not applied.
+	   the tag is optional, the default value is true
+	*/
+	TAG_RELOADABLE = "reloadable"
 )
 
 func (this *ArgumentParser) addStructArgument(prefix string, tpVal reflect.Value) error {
@@ -332,11 +450,35 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	shorttoken := tagMap[TAG_SHORT_TOKEN]
 	alias := tagMap[TAG_ALIAS]
 	negative := tagMap[TAG_NEGATIVE_TOKEN]
+	envName := tagMap[TAG_ENV]
+	secret := tagMap[TAG_SECRET] == "true" || tagMap[TAG_SENSITIVE] == "true"
+	fromFile := secret
+	if fileTag := tagMap[TAG_FILE]; len(fileTag) > 0 {
+		switch fileTag {
+		case "true":
+			fromFile = true
+		case "false":
+			fromFile = false
+		default:
+			return fmt.Errorf("Invalid file tag %q, neither true nor false", fileTag)
+		}
+	}
 	metavar := tagMap[TAG_METAVAR]
 	defval := tagMap[TAG_DEFAULT]
-	if len(defval) > 0 {
+	var defEnvNames []string
+	defLiteral := ""
+	if len(defval) > 0 && !hasReference(defval) {
+		// the variables are kept for the help, not their values
+		for _, dv := range strings.Split(defval, "|") {
+			if len(dv) > 0 && dv[0] == '$' {
+				defEnvNames = append(defEnvNames, strings.TrimLeft(dv, "$"))
+			} else if len(defEnvNames) > 0 && len(dv) > 0 {
+				defLiteral = dv
+				break
+			}
+		}
 		for _, dv := range strings.Split(defval, "|") {
-			if dv[0] == '$' {
+			if len(dv) > 0 && dv[0] == '$' {
 				dv = os.Getenv(strings.TrimLeft(dv, "$"))
 			}
 			defval = dv
@@ -400,7 +542,12 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 		subcommand = false
 	}
 	var defval_t reflect.Value
-	if use_default {
+	defTemplate := ""
+	if use_default && hasReference(defval) {
+		// expanded when the default is set
+		defTemplate = defval
+		defval_t = reflect.Zero(fv.Type())
+	} else if use_default {
 		defval_t, err = gotypes.ParseValue(defval, fv.Type())
 		if err != nil {
 			return err
@@ -413,20 +560,27 @@ func (this *ArgumentParser) addArgument(prefix string, fv reflect.Value, info *r
 	ovalue := reflect.New(fv.Type()).Elem()
 	ovalue.Set(fv)
 	sarg := SingleArgument{
-		token:      token,
-		shortToken: shorttoken,
-		aliasToken: alias,
-		negaToken:  negative,
-		positional: positional,
-		required:   required,
-		metavar:    metavar,
-		help:       help,
-		choices:    choices,
-		useDefault: use_default,
-		defValue:   defval_t,
-		value:      fv,
-		ovalue:     ovalue,
-		parser:     this,
+		token:       token,
+		shortToken:  shorttoken,
+		aliasToken:  alias,
+		negaToken:   negative,
+		envName:     envName,
+		secret:      secret,
+		fromFile:    fromFile && !positional,
+		noReload:    tagMap[TAG_RELOADABLE] == "false",
+		defTemplate: defTemplate,
+		defEnvNames: defEnvNames,
+		defLiteral:  defLiteral,
+		positional:  positional,
+		required:    required,
+		metavar:     metavar,
+		help:        help,
+		choices:     choices,
+		useDefault:  use_default,
+		defValue:    defval_t,
+		value:       fv,
+		ovalue:      ovalue,
+		parser:      this,
 	}
 	// fmt.Println(token, f.Type, f.Type.Kind())
 	if subcommand {
@@ -515,14 +669,82 @@ func (this *ArgumentParser) AddArgument(arg Argument) error {
 }
 
 func (this *ArgumentParser) SetDefault() {
-	for _, arg := range this.posArgs {
-		arg.SetDefault()
+	if err := this.setDefaults(); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+// setDefaults sets the arguments not set to their default values, the
+// references of the default values are expanded first
+func (this *ArgumentParser) setDefaults() error {
+	err := this.expandDefaults()
+	for _, args := range [][]Argument{this.posArgs, this.optArgs} {
+		for _, arg := range args {
+			arg.SetDefault()
+			if defArg, ok := arg.(interface {
+				hasDefault() bool
+			}); ok && defArg.hasDefault() && !arg.IsSet() {
+				this.setSource(arg, ValueSource{Kind: SourceDefault})
+			}
+		}
 	}
-	for _, arg := range this.optArgs {
-		arg.SetDefault()
+	return err
+}
+
+// SetAllowAbbrev sets whether an optional argument can be abbreviated on the
+// command line, e.g. --admin-u for --admin-user.  Abbreviation is allowed by
+// default.  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetAllowAbbrev(allow bool) {
+	this.noAbbrev = !allow
+	for _, subparser := range this.subParsers() {
+		subparser.SetAllowAbbrev(allow)
+	}
+}
+
+// SetFailFast sets whether parsing and validation stop at the first failure.
+// By default all conversion, choices and validation failures are collected
+// and returned as ValidationErrors.  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetFailFast(failFast bool) {
+	this.failFast = failFast
+	for _, subparser := range this.subParsers() {
+		subparser.SetFailFast(failFast)
+	}
+}
+
+// SetStrictConfig sets whether config sources are parsed strictly.  In
+// strict mode unknown keys are returned as an UnknownConfigKeysError
+// instead of logged.  The setting also applies to sub parsers.
+func (this *ArgumentParser) SetStrictConfig(strict bool) {
+	this.strictConfig = strict
+	for _, subparser := range this.subParsers() {
+		subparser.SetStrictConfig(strict)
 	}
 }
 
+func (this *ArgumentParser) subParsers() []*ArgumentParser {
+	ret := make([]*ArgumentParser, 0)
+	subcmd := this.GetSubcommand()
+	if subcmd != nil {
+		for _, cmd := range subcmd.choices {
+			ret = append(ret, subcmd.subcommands[cmd].parser)
+		}
+	}
+	return ret
+}
+
+// inheritSettings copies parser settings from the parent parser to a sub
+// parser
+func (this *ArgumentParser) inheritSettings(parent *ArgumentParser) {
+	this.noAbbrev = parent.noAbbrev
+	this.failFast = parent.failFast
+	this.strictConfig = parent.strictConfig
+	this.envPrefix = parent.envPrefix
+	this.envSeparator = parent.envSeparator
+	this.helpWidth = parent.helpWidth
+	this.noHelpAnnotations = parent.noHelpAnnotations
+	this.helpFormatter = parent.helpFormatter
+}
+
 func (this *ArgumentParser) Options() interface{} {
 	return this.target
 }
@@ -579,13 +801,15 @@ func splitCamelString(str string) string {
 	return utils.CamelSplit(str, "-")
 }
 
+// AllToken returns all tokens of the argument in getopt style, the short
+// token comes first, e.g. "-p|--port|--listen-port"
 func (this *SingleArgument) AllToken() string {
-	ret := this.Token()
+	ret := "--" + this.Token()
 	if len(this.AliasToken()) != 0 {
 		ret = fmt.Sprintf("%s|--%s", ret, this.AliasToken())
 	}
 	if len(this.ShortToken()) != 0 {
-		ret = fmt.Sprintf("%s|-%s", ret, this.ShortToken())
+		ret = fmt.Sprintf("-%s|%s", this.ShortToken(), ret)
 	}
 	if len(this.NegativeToken()) != 0 {
 		ret = fmt.Sprintf("%s/--%s", ret, this.NegativeToken())
@@ -622,9 +846,9 @@ func (this *SingleArgument) String() string {
 		return fmt.Sprintf("%c%s%c", start, this.MetaVar(), end)
 	} else {
 		if this.NeedData() {
-			return fmt.Sprintf("%c--%s %s%c", start, this.AllToken(), this.MetaVar(), end)
+			return fmt.Sprintf("%c%s %s%c", start, this.AllToken(), this.MetaVar(), end)
 		} else {
-			return fmt.Sprintf("%c--%s%c", start, this.AllToken(), end)
+			return fmt.Sprintf("%c%s%c", start, this.AllToken(), end)
 		}
 	}
 }
@@ -668,28 +892,25 @@ func (this *SingleArgument) Choices() []string {
 
 func (this *SingleArgument) SetValue(val string) error {
 	if !this.InChoices(val) {
-		return this.choicesErr(val)
+		return this.choicesErr(this, val)
 	}
 	e := gotypes.SetValue(this.value, val)
 	if e != nil {
-		return e
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: this, Value: val, Err: e}
 	}
 	this.isSet = true
 	return nil
 }
 
-func (this *SingleArgument) choicesErr(val string) error {
-	cands := FindSimilar(val, this.choices, -1, 0.5)
-	if len(cands) > 3 {
-		cands = cands[:3]
-	}
-	msg := fmt.Sprintf("Unknown argument '%s' for %s", val, this.Token())
-	if len(cands) > 0 {
-		msg += fmt.Sprintf(", did you mean %s?", quotedChoicesString(cands))
-	} else if len(this.choices) > 0 {
-		msg += fmt.Sprintf(", accepts %s", quotedChoicesString(this.choices))
+func (this *SingleArgument) choicesErr(arg Argument, val string) error {
+	cands := suggestSimilar(val, this.choices)
+	return &InvalidChoiceError{
+		ErrorPosition: noPosition(),
+		Argument:      arg,
+		Value:         val,
+		Choices:       this.choices,
+		Suggestions:   cands,
 	}
-	return fmt.Errorf("%s", msg)
 }
 
 func (this *SingleArgument) Reset() {
@@ -721,7 +942,7 @@ func (this *SingleArgument) SetDefault() {
 
 func (this *SingleArgument) Validate() error {
 	if this.required && !this.isSet && !this.useDefault {
-		return fmt.Errorf("Non-optional argument %s not set", this.token)
+		return &RequiredArgumentError{Argument: this}
 	}
 	return nil
 }
@@ -746,12 +967,14 @@ func (this *MultiArgument) setKeyValue(val string) error {
 	keyType := this.value.Type().Key()
 	keyValue, err := gotypes.ParseValue(key, keyType)
 	if err != nil {
-		return errors.Wrapf(err, "ParseValue for key %s", key)
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: this, Value: val,
+			Err: errors.Wrapf(err, "ParseValue for key %s", key)}
 	}
 	valType := this.value.Type().Elem()
 	valValue, err := gotypes.ParseValue(value, valType)
 	if err != nil {
-		return errors.Wrapf(err, "ParseValue for value %s", value)
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: this, Value: val,
+			Err: errors.Wrapf(err, "ParseValue for value %s", value)}
 	}
 	if this.value.Len() == 0 {
 		this.value.Set(reflect.MakeMap(this.value.Type()))
@@ -766,28 +989,24 @@ func (this *MultiArgument) SetValue(val string) error {
 		return this.setKeyValue(val)
 	}
 	if !this.InChoices(val) {
-		return this.choicesErr(val)
+		return this.choicesErr(this, val)
 	}
 	var e error = nil
 	e = gotypes.AppendValue(this.value, val)
 	if e != nil {
-		return e
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: this, Value: val, Err: e}
 	}
 	this.isSet = true
 	return nil
 }
 
 func (this *MultiArgument) Validate() error {
-	var e = this.SingleArgument.Validate()
-	if e != nil {
-		return e
+	if this.required && !this.isSet && !this.useDefault {
+		return &RequiredArgumentError{Argument: this}
 	}
 	var vallen int64 = int64(this.value.Len())
-	if this.minCount >= 0 && vallen < this.minCount {
-		return fmt.Errorf("Argument count requires at least %d", this.minCount)
-	}
-	if this.maxCount >= 0 && vallen > this.maxCount {
-		return fmt.Errorf("Argument count requires at most %d", this.maxCount)
+	if (this.minCount >= 0 && vallen < this.minCount) || (this.maxCount >= 0 && vallen > this.maxCount) {
+		return &ArgumentCountError{Argument: this, Count: vallen, Min: this.minCount, Max: this.maxCount}
 	}
 	return nil
 }
@@ -800,47 +1019,146 @@ func (this *SubcommandArgument) String() string {
 	return fmt.Sprintf("<%s>", strings.ToUpper(this.token))
 }
 
+func (this *SubcommandArgument) SetValue(val string) error {
+	if _, ok := this.subcommands[val]; !ok {
+		return this.unknownSubcommandErr(val)
+	}
+	return this.SingleArgument.SetValue(val)
+}
+
+func (this *SubcommandArgument) unknownSubcommandErr(cmd string) error {
+	cands := suggestSimilar(cmd, this.choices)
+	if len(cands) == 0 {
+		// look for the command among the subcommands of the sub parsers,
+		// e.g. "list" for "server list"
+		paths := make(map[string][]string)
+		this.collectNestedSubcommands(nil, paths)
+		names := make([]string, 0, len(paths))
+		for name := range paths {
+			names = append(names, name)
+		}
+		for _, name := range suggestSimilar(cmd, names) {
+			cands = append(cands, paths[name]...)
+		}
+		if len(cands) > maxSuggestions {
+			cands = cands[:maxSuggestions]
+		}
+	}
+	return &UnknownSubcommandError{
+		ErrorPosition: noPosition(),
+		Argument:      this,
+		Command:       cmd,
+		Subcommands:   this.choices,
+		Suggestions:   cands,
+	}
+}
+
 func (this *SubcommandArgument) AddSubParser(target interface{}, command string, desc string, callback interface{}) (*ArgumentParser, error) {
-	return this.addSubParser(target, command, desc, callback)
+	return this.addSubParser(target, command, desc, "", callback)
 }
 
 func (this *SubcommandArgument) AddSubParserWithHelp(target interface{}, command string, desc string, callback interface{}) (*ArgumentParser, error) {
-	return this.addSubParser(target, command, desc, callback)
+	return this.addSubParser(target, command, desc, "", callback)
 }
 
-func (this *SubcommandArgument) addSubParser(target interface{}, command string, desc string, callback interface{}) (*ArgumentParser, error) {
+// AddSubParserWithCategory adds a subcommand listed under the category
+// heading in the help, e.g. "Servers"
+func (this *SubcommandArgument) AddSubParserWithCategory(target interface{}, command string, desc string, category string, callback interface{}) (*ArgumentParser, error) {
+	return this.addSubParser(target, command, desc, category, callback)
+}
+
+func (this *SubcommandArgument) addSubParser(target interface{}, command string, desc string, category string, callback interface{}) (*ArgumentParser, error) {
 	prog := fmt.Sprintf("%s %s", this.parser.prog, command)
 	parser, e := newArgumentParser(target, prog, desc, "")
 	if e != nil {
 		return nil, e
 	}
+	parser.inheritSettings(this.parser)
 	cbfunc := reflect.ValueOf(callback)
+	if _, ok := this.subcommands[command]; !ok {
+		this.choices = append(this.choices, command)
+	}
 	this.subcommands[command] = SubcommandArgumentData{parser: parser,
-		callback: cbfunc}
-	this.choices = append(this.choices, command)
+		callback: cbfunc, category: category}
 	return parser, nil
 }
 
+// SetSubcommandOrder sets the order of the subcommands and their categories
+// in the help, SubcommandOrderRegistration by default
+func (this *SubcommandArgument) SetSubcommandOrder(order SubcommandOrder) {
+	this.order = order
+}
+
+// sortedSubcommands returns the categories and their subcommands in the
+// order of the help, uncategorized subcommands first under ""
+func (this *SubcommandArgument) sortedSubcommands() ([]string, map[string][]string) {
+	categories := make([]string, 0)
+	commands := make(map[string][]string)
+	for _, cmd := range this.choices {
+		category := this.subcommands[cmd].category
+		if _, ok := commands[category]; !ok && len(category) > 0 {
+			categories = append(categories, category)
+		}
+		commands[category] = append(commands[category], cmd)
+	}
+	if this.order == SubcommandOrderAlphabetical {
+		sort.Strings(categories)
+		for _, cmds := range commands {
+			sort.Strings(cmds)
+		}
+	}
+	if _, ok := commands[""]; ok {
+		categories = append([]string{""}, categories...)
+	}
+	return categories, commands
+}
+
 func (this *SubcommandArgument) HelpString(indent string) string {
 	var buf bytes.Buffer
-	for k, data := range this.subcommands {
-		buf.WriteString(indent)
-		buf.WriteString(k)
-		buf.WriteByte('\n')
-		buf.WriteString(indent)
-		buf.WriteString("  ")
-		buf.WriteString(data.parser.ShortDescription())
-		buf.WriteByte('\n')
+	for _, group := range this.helpSubcommands() {
+		cmdIndent := indent
+		if len(group.Category) > 0 {
+			buf.WriteString(indent)
+			buf.WriteString(group.Category)
+			buf.WriteString(":\n")
+			cmdIndent = indent + "  "
+		}
+		for _, cmd := range group.Subcommands {
+			buf.WriteString(cmdIndent)
+			buf.WriteString(cmd.Name)
+			buf.WriteByte('\n')
+			buf.WriteString(cmdIndent)
+			buf.WriteString("  ")
+			buf.WriteString(cmd.Description)
+			buf.WriteByte('\n')
+		}
 	}
 	return buf.String()
 }
 
+// collectNestedSubcommands collects subcommands of the sub parsers, maps
+// the subcommand name to its full command paths
+func (this *SubcommandArgument) collectNestedSubcommands(path []string, paths map[string][]string) {
+	for _, cmd := range this.choices {
+		subcmd := this.subcommands[cmd].parser.GetSubcommand()
+		if subcmd == nil {
+			continue
+		}
+		subpath := append(append([]string{}, path...), cmd)
+		for _, subname := range subcmd.choices {
+			fullpath := strings.Join(append(append([]string{}, subpath...), subname), " ")
+			paths[subname] = append(paths[subname], fullpath)
+		}
+		subcmd.collectNestedSubcommands(subpath, paths)
+	}
+}
+
 func (this *SubcommandArgument) SubHelpString(cmd string) (string, error) {
 	val, ok := this.subcommands[cmd]
 	if ok {
 		return val.parser.HelpString(), nil
 	} else {
-		return "", fmt.Errorf("No such command %s", cmd)
+		return "", this.unknownSubcommandErr(cmd)
 	}
 }
 
@@ -862,7 +1180,7 @@ func (this *SubcommandArgument) Invoke(args ...interface{}) error {
 	var cmd = this.value.String()
 	val, ok := this.subcommands[cmd]
 	if !ok {
-		return fmt.Errorf("Unknown subcommand %s", cmd)
+		return this.unknownSubcommandErr(cmd)
 	}
 	out := val.callback.Call(inargs)
 	if len(out) == 1 {
@@ -880,63 +1198,115 @@ func (this *ArgumentParser) ShortDescription() string {
 	return strings.Split(this.description, "\n")[0]
 }
 
-func (this *ArgumentParser) Usage() string {
-	var buf bytes.Buffer
-	buf.WriteString("Usage: ")
-	buf.WriteString(this.prog)
-	for _, arg := range this.optArgs {
-		buf.WriteByte(' ')
-		buf.WriteString(arg.String())
+func splitInlineValue(token string) (string, string, bool) {
+	pos := strings.IndexByte(token, '=')
+	if pos < 0 {
+		return token, "", false
 	}
-	for _, arg := range this.posArgs {
-		buf.WriteByte(' ')
-		buf.WriteString(arg.String())
-		if arg.IsSubcommand() || arg.IsMulti() {
-			buf.WriteString(" ...")
-		}
+	return token[:pos], token[pos+1:], true
+}
+
+func setBoolValue(arg Argument, value string, nega bool) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return &InvalidValueError{ErrorPosition: noPosition(), Argument: arg, Value: value, Err: err}
 	}
-	buf.WriteByte('\n')
-	buf.WriteByte('\n')
-	return buf.String()
+	if nega {
+		v = !v
+	}
+	return arg.SetValue(fmt.Sprintf("%t", v))
 }
 
-func (this *ArgumentParser) HelpString() string {
-	var buf bytes.Buffer
-	buf.WriteString(this.Usage())
-	buf.WriteString(this.description)
-	buf.WriteByte('\n')
-	buf.WriteByte('\n')
-	if len(this.posArgs) > 0 {
-		buf.WriteString("Positional arguments:\n")
-		for _, arg := range this.posArgs {
-			buf.WriteString("    ")
-			buf.WriteString(arg.String())
-			buf.WriteByte('\n')
-			buf.WriteString(arg.HelpString("        "))
-			buf.WriteByte('\n')
+// similarTokens returns tokens, including alias, short and negative ones,
+// that are similar to the unknown input, e.g. --region for --regoin
+func (this *ArgumentParser) similarTokens(input string) []string {
+	names := make([]string, 0)
+	tokens := make(map[string]string)
+	addToken := func(name, token string) {
+		if len(name) > 0 {
+			if _, ok := tokens[name]; !ok {
+				names = append(names, name)
+				tokens[name] = token
+			}
 		}
-		buf.WriteByte('\n')
 	}
-	if len(this.optArgs) > 0 {
-		buf.WriteString("Optional arguments:\n")
-		for _, arg := range this.optArgs {
-			buf.WriteString("    ")
-			buf.WriteString(arg.String())
-			buf.WriteByte('\n')
-			buf.WriteString(arg.HelpString("        "))
-			buf.WriteByte('\n')
+	for _, arg := range this.optArgs {
+		addToken(arg.Token(), "--"+arg.Token())
+		addToken(arg.AliasToken(), "--"+arg.AliasToken())
+		addToken(arg.NegativeToken(), "--"+arg.NegativeToken())
+		addToken(arg.ShortToken(), "-"+arg.ShortToken())
+	}
+	name, _, _ := splitInlineValue(strings.TrimLeft(input, "-"))
+	cands := suggestSimilar(name, names)
+	for i := range cands {
+		cands[i] = tokens[cands[i]]
+	}
+	return cands
+}
+
+func (this *ArgumentParser) findShortArgument(short string) Argument {
+	for _, arg := range this.optArgs {
+		if arg.ShortToken() == short {
+			return arg
 		}
-		buf.WriteByte('\n')
 	}
-	if len(this.epilog) > 0 {
-		buf.WriteString(this.epilog)
-		buf.WriteByte('\n')
-		buf.WriteByte('\n')
+	return nil
+}
+
+// isShortCluster tells whether a single dash argument, without the leading
+// dash, should be parsed as a cluster of single character short tokens.
+// Arguments matching a token exactly, e.g. "-debug", are still parsed as
+// long tokens for compatibility.
+func (this *ArgumentParser) isShortCluster(cluster string) bool {
+	if len(cluster) == 0 {
+		return false
+	}
+	token, _, _ := splitInlineValue(cluster)
+	if arg, _, _ := this.findOptionalArgument(token, true); arg != nil {
+		return false
 	}
-	return buf.String()
+	return this.findShortArgument(cluster[:1]) != nil
+}
+
+// parseShortCluster parses args[i] as a cluster of short tokens, e.g. -vdf,
+// -p8080, -vp 8080.  It returns the number of extra arguments consumed.
+func (this *ArgumentParser) parseShortCluster(args []string, i int, ignoreUnknown bool) (int, error) {
+	cluster := args[i][1:]
+	for j := 0; j < len(cluster); j++ {
+		arg := this.findShortArgument(cluster[j : j+1])
+		if arg == nil {
+			if ignoreUnknown {
+				continue
+			}
+			return 0, &UnknownArgumentError{ErrorPosition: noPosition(), Input: "-" + cluster[j:j+1],
+				Suggestions: this.similarTokens(cluster[j : j+1])}
+		}
+		this.overrideArgument(arg)
+		rest := cluster[j+1:]
+		if arg.NeedData() {
+			// the rest of the cluster is the value, otherwise the next argument
+			if len(rest) > 0 {
+				return 0, arg.SetValue(strings.TrimPrefix(rest, "="))
+			}
+			if i+1 < len(args) {
+				return 1, arg.SetValue(args[i+1])
+			}
+			return 0, &MissingValueError{ErrorPosition: noPosition(), Argument: arg, Input: "-" + arg.ShortToken()}
+		}
+		if strings.HasPrefix(rest, "=") {
+			return 0, setBoolValue(arg, rest[1:], false)
+		}
+		if err := arg.DoAction(false); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
 }
 
 func tokenMatch(argToken, input string, exactMatch bool) bool {
+	if len(argToken) == 0 {
+		return false
+	}
 	if exactMatch {
 		return argToken == input
 	} else {
@@ -944,61 +1314,88 @@ func tokenMatch(argToken, input string, exactMatch bool) bool {
 	}
 }
 
-func (this *ArgumentParser) findOptionalArgument(token string, exactMatch bool) (Argument, bool) {
-	var match_arg Argument = nil
-	match_len := -1
-	negative := false
+func (this *ArgumentParser) matchOptionalArgument(token string, exactMatch bool, onMatch func(arg Argument, matched string, nega bool)) {
 	for _, arg := range this.optArgs {
 		if tokenMatch(arg.Token(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.Token()) {
-				match_len = len(arg.Token())
-				match_arg = arg
-				negative = false
-			}
+			onMatch(arg, "--"+arg.Token(), false)
 		} else if tokenMatch(arg.ShortToken(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.ShortToken()) {
-				match_len = len(arg.ShortToken())
-				match_arg = arg
-				negative = false
-			}
+			onMatch(arg, "-"+arg.ShortToken(), false)
 		} else if tokenMatch(arg.AliasToken(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.AliasToken()) {
-				match_len = len(arg.AliasToken())
-				match_arg = arg
-				negative = false
-			}
+			onMatch(arg, "--"+arg.AliasToken(), false)
 		} else if tokenMatch(arg.NegativeToken(), token, exactMatch) {
-			if match_len < 0 || match_len > len(arg.AliasToken()) {
-				match_len = len(arg.AliasToken())
-				match_arg = arg
-				negative = true
-			}
+			onMatch(arg, "--"+arg.NegativeToken(), true)
+		}
+	}
+}
+
+// findOptionalArgument looks up the optional argument by token.  An exact
+// match always wins, otherwise, unless exactMatch is set or abbreviation is
+// disabled, the token is matched as a prefix of the argument tokens and an
+// AmbiguousArgumentError is returned if it matches more than one argument.
+func (this *ArgumentParser) findOptionalArgument(token string, exactMatch bool) (Argument, bool, error) {
+	var match_arg Argument = nil
+	negative := false
+	this.matchOptionalArgument(token, true, func(arg Argument, matched string, nega bool) {
+		if match_arg == nil {
+			match_arg = arg
+			negative = nega
 		}
+	})
+	if match_arg != nil || exactMatch || this.noAbbrev {
+		return match_arg, negative, nil
+	}
+	candidates := make([]string, 0)
+	this.matchOptionalArgument(token, false, func(arg Argument, matched string, nega bool) {
+		if match_arg == nil {
+			match_arg = arg
+			negative = nega
+		}
+		candidates = append(candidates, matched)
+	})
+	if len(candidates) > 1 {
+		sort.Strings(candidates)
+		return nil, false, &AmbiguousArgumentError{Token: token, Candidates: candidates}
 	}
-	return match_arg, negative
+	return match_arg, negative, nil
 }
 
-func validateArgs(args []Argument) error {
+// validateArgs validates args except those in skip, returns all failures
+// unless failFast is set
+func validateArgs(args []Argument, skip map[Argument]bool, failFast bool) []error {
+	errs := make([]error, 0)
 	for _, arg := range args {
+		if skip[arg] {
+			continue
+		}
 		e := arg.Validate()
 		if e != nil {
-			return fmt.Errorf("%s error: %s", arg.Token(), e)
+			switch e.(type) {
+			case *RequiredArgumentError, *ArgumentCountError:
+			default:
+				e = &ArgumentError{Argument: arg, Err: e}
+			}
+			errs = append(errs, e)
+			if failFast {
+				break
+			}
 		}
 	}
-	return nil
+	return errs
 }
 
-func (this *ArgumentParser) Validate() error {
-	var e error = nil
-	e = validateArgs(this.posArgs)
-	if e != nil {
-		return e
-	}
-	e = validateArgs(this.optArgs)
-	if e != nil {
-		return e
+func (this *ArgumentParser) validate(skip map[Argument]bool) []error {
+	errs := validateArgs(this.posArgs, skip, this.failFast)
+	if len(errs) > 0 && this.failFast {
+		return errs
 	}
-	return nil
+	return append(errs, validateArgs(this.optArgs, skip, this.failFast)...)
+}
+
+// Validate validates all arguments.  It returns the failure if only one
+// argument fails, otherwise a ValidationErrors listing all failures, or
+// only the first failure if fail fast is set.
+func (this *ArgumentParser) Validate() error {
+	return joinErrors(this.validate(nil))
 }
 
 func (this *ArgumentParser) reset() {
@@ -1009,6 +1406,10 @@ func (this *ArgumentParser) reset() {
 		arg.Reset()
 	}
 	this.help = false
+	this.remainingArgs = nil
+	this.sources = nil
+	this.cliValues = nil
+	this.cliFiles = nil
 }
 
 func (this *ArgumentParser) ParseArgs(args []string, ignore_unknown bool) error {
@@ -1016,42 +1417,112 @@ func (this *ArgumentParser) ParseArgs(args []string, ignore_unknown bool) error
 }
 
 func (this *ArgumentParser) ParseArgs2(args []string, ignore_unknown bool, setDefaults bool) error {
+	// conversion and choices errors are collected and parsing continues
+	ec := newErrorCollector(this.failFast)
+	this.reset()
+	err := this.parseArgs(args, ignore_unknown, ec)
+	if err == nil {
+		// environment variables come after command line arguments
+		err = ec.collectAll(this.parseEnv())
+	}
+	return this.finishParse(err, ec, setDefaults)
+}
+
+// finishParse validates the arguments and returns all collected errors
+func (this *ArgumentParser) finishParse(err error, ec *errorCollector, setDefaults bool) error {
+	if err == nil {
+		ec.errs = append(ec.errs, this.validate(ec.failed)...)
+	} else {
+		ec.errs = append(ec.errs, err)
+	}
+	if setDefaults {
+		ec.errs = append(ec.errs, this.setDefaults())
+	}
+	return joinErrors(ec.errs)
+}
+
+// parseArgs parses the command line arguments on top of the current values
+func (this *ArgumentParser) parseArgs(args []string, ignore_unknown bool, ec *errorCollector) error {
 	var pos_idx int
 	var err error
 	var argStr string
-
-	this.reset()
+	var optionsEnd bool
 
 	for i := 0; i < len(args) && err == nil; i++ {
 		argStr = args[i]
-		if argStr == "--help" {
+		if optionsEnd {
+			// after "--", everything is positional
+		} else if argStr == "--" {
+			// end of options, keep the untouched tail
+			optionsEnd = true
+			this.remainingArgs = append([]string{}, args[i+1:]...)
+			continue
+		} else if argStr == "--help" {
 			// shortcut to show help
 			fmt.Println(this.HelpString())
 			this.help = true
 			continue
 		}
-		if strings.HasPrefix(argStr, "-") {
-			arg, nega := this.findOptionalArgument(strings.TrimLeft(argStr, "-"), false)
+		if !optionsEnd && strings.HasPrefix(argStr, "-") && argStr != "-" {
+			if !strings.HasPrefix(argStr, "--") && this.isShortCluster(argStr[1:]) {
+				// POSIX style short options, e.g. -vdf or -p8080
+				var consumed int
+				consumed, err = this.parseShortCluster(args, i, ignore_unknown)
+				err = ec.collect(err, i+consumed)
+				if err != nil {
+					break
+				}
+				i += consumed
+				continue
+			}
+			// --token=value or -s=value, split on the first '='
+			token, value, hasValue := splitInlineValue(strings.TrimLeft(argStr, "-"))
+			var arg Argument
+			var nega bool
+			arg, nega, err = this.findOptionalArgument(token, false)
+			if err != nil {
+				err = withPosition(err, i)
+				break
+			}
 			if arg != nil {
+				this.overrideArgument(arg)
 				if arg.NeedData() {
-					if i+1 < len(args) {
-						err = arg.SetValue(args[i+1])
+					if hasValue {
+						err = ec.collect(arg.SetValue(value), i)
+						if err != nil {
+							break
+						}
+					} else if i+1 < len(args) {
+						err = ec.collect(arg.SetValue(args[i+1]), i+1)
 						if err != nil {
 							break
 						}
 						i++
 					} else {
-						err = fmt.Errorf("Missing arguments for %s", argStr)
+						err = &MissingValueError{ErrorPosition: ErrorPosition{Position: i}, Argument: arg, Input: argStr}
+						break
+					}
+				} else if hasValue {
+					// explicit boolean value, e.g. --debug=false
+					err = ec.collect(setBoolValue(arg, value, nega), i)
+					if err != nil {
 						break
 					}
 				} else {
 					err = arg.DoAction(nega)
 					if err != nil {
+						err = withPosition(err, i)
 						break
 					}
 				}
 			} else if !ignore_unknown {
-				err = fmt.Errorf("Unknown optional argument %s", argStr)
+				// the inline value is left out, it may be a secret
+				input := argStr
+				if hasValue {
+					input = argStr[:len(argStr)-len(value)-1]
+				}
+				err = &UnknownArgumentError{ErrorPosition: ErrorPosition{Position: i}, Input: input,
+					Suggestions: this.similarTokens(input)}
 				break
 			}
 		} else {
@@ -1059,19 +1530,28 @@ func (this *ArgumentParser) ParseArgs2(args []string, ignore_unknown bool, setDe
 				if len(this.posArgs) > 0 {
 					last_arg := this.posArgs[len(this.posArgs)-1]
 					if last_arg.IsMulti() {
-						last_arg.SetValue(argStr)
-					} else if !ignore_unknown {
-						err = fmt.Errorf("Unknown positional argument %s", argStr)
+						this.overrideArgument(last_arg)
+						err = ec.collect(last_arg.SetValue(argStr), i)
+						if err != nil {
+							break
+						}
+					} else if !ignore_unknown && !optionsEnd {
+						err = &UnknownArgumentError{ErrorPosition: ErrorPosition{Position: i}, Input: argStr, Positional: true}
 						break
 					}
-				} else if !ignore_unknown {
-					err = fmt.Errorf("Unknown positional argument %s", argStr)
+				} else if !ignore_unknown && !optionsEnd {
+					err = &UnknownArgumentError{ErrorPosition: ErrorPosition{Position: i}, Input: argStr, Positional: true}
 					break
 				}
 			} else {
 				arg := this.posArgs[pos_idx]
 				pos_idx += 1
-				err = arg.SetValue(argStr)
+				this.overrideArgument(arg)
+				if arg.IsSubcommand() {
+					err = withPosition(arg.SetValue(argStr), i)
+				} else {
+					err = ec.collect(arg.SetValue(argStr), i)
+				}
 				if err != nil {
 					break
 				}
@@ -1079,19 +1559,19 @@ func (this *ArgumentParser) ParseArgs2(args []string, ignore_unknown bool, setDe
 					subarg := arg.(*SubcommandArgument)
 					var subparser = subarg.GetSubParser()
 					err = subparser.ParseArgs(args[i+1:], ignore_unknown)
+					if err != nil {
+						// positions are relative to the sub-command arguments
+						err = shiftPosition(err, i+1)
+					}
 					break
 				}
 			}
 		}
 	}
+	this.setSources(ValueSource{Kind: SourceCLI})
+	this.saveCLIValues()
 	if err == nil && pos_idx < len(this.posArgs) {
-		err = &NotEnoughArgumentsError{argument: this.posArgs[pos_idx]}
-	}
-	if err == nil {
-		err = this.Validate()
-	}
-	if setDefaults {
-		this.SetDefault()
+		err = &NotEnoughArgumentsError{Argument: this.posArgs[pos_idx]}
 	}
 	return err
 }
@@ -1104,14 +1584,16 @@ func isQuoted(str string) bool {
 	return isQuotedByChar(str, '"') || isQuotedByChar(str, '\'')
 }
 
-func (this *ArgumentParser) parseKeyValue(key, value string) error {
-	arg, nega := this.findOptionalArgument(key, true)
+// parseKeyValue sets the argument of a tornado style key/value pair, pos is
+// the position of the pair for warnings
+func (this *ArgumentParser) parseKeyValue(key, value string, pos string) error {
+	arg, nega, _ := this.findOptionalArgument(key, true)
 	if arg != nil {
 		if nega {
-			log.Warningf("Ignore negative token when parse %s=%v", key, value)
+			log.Warningf("%s: Ignore negative token when parse %s=%v", pos, key, redactString(arg, value))
 			return nil
 		}
-		if arg.IsSet() {
+		if !this.overrideArgument(arg) {
 			return nil
 		}
 		if arg.IsMulti() {
@@ -1122,7 +1604,7 @@ func (this *ArgumentParser) parseKeyValue(key, value string) error {
 			}
 			values := utils.FindWords([]byte(value), 0)
 			for _, v := range values {
-				e := arg.SetValue(v)
+				e := this.setConfigValue(arg, v)
 				if e != nil {
 					return e
 				}
@@ -1133,24 +1615,38 @@ func (this *ArgumentParser) parseKeyValue(key, value string) error {
 			}
 			values := utils.FindWords([]byte(value), 0)
 			if len(values) == 1 {
-				return arg.SetValue(values[0])
+				return this.setConfigValue(arg, values[0])
 			} else {
-				log.Warningf("too many arguments %#v for %s", values, key)
+				log.Warningf("%s: too many arguments %s for %s", pos, redactString(arg, fmt.Sprintf("%#v", values)), key)
 			}
 		}
 	} else {
-		log.Warningf("Cannot find argument %s", key)
+		return unknownConfigKeyErr(key)
 	}
 	return nil
 }
 
+// removeComments cuts the line at the first '#' outside quoted words, the
+// quotes and escapes are those of utils.FindWords, e.g. a password
+// 'p#ss\'word' is kept
 func removeComments(line string) string {
-	pos := strings.IndexByte(line, '#')
-	if pos >= 0 {
-		return line[:pos]
-	} else {
-		return line
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '#':
+			return line[:i]
+		case (c == '\'' || c == '"') && (i == 0 || strings.IndexByte(" \t=,[(", line[i-1]) >= 0):
+			quote = c
+		}
 	}
+	return line
 }
 
 func line2KeyValue(line string) (string, string, error) {
@@ -1175,32 +1671,144 @@ func removeCharacters(input, charSet string) string {
 	return strings.Map(filter, input)
 }
 
+type ConfigFormat string
+
+const (
+	ConfigFormatYAML    = ConfigFormat("yaml")
+	ConfigFormatJSON    = ConfigFormat("json")
+	ConfigFormatTOML    = ConfigFormat("toml")
+	ConfigFormatTornado = ConfigFormat("tornado")
+)
+
+// memorySource is the name of an in-memory config source of the format,
+// in place of the file path
+func memorySource(format ConfigFormat) string {
+	return "<" + string(format) + ">"
+}
+
+// ParseYAMLFile sets the arguments from a YAML file, the files listed by
+// the "include" or "includes" key are parsed as well, see ParseConfigDir
 func (this *ArgumentParser) ParseYAMLFile(filepath string) error {
 	content, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return fmt.Errorf("read file %s: %v", filepath, err)
 	}
-	obj, err := jsonutils.ParseYAML(string(content))
+	return this.parseYAML(content, filepath)
+}
+
+func (this *ArgumentParser) ParseJSONFile(filepath string) error {
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("read file %s: %v", filepath, err)
+	}
+	return this.parseJSON(content, filepath)
+}
+
+// ParseYAML sets the arguments from a YAML document in memory
+func (this *ArgumentParser) ParseYAML(data []byte) error {
+	return this.parseYAML(data, memorySource(ConfigFormatYAML))
+}
+
+// ParseJSON sets the arguments from a JSON document in memory
+func (this *ArgumentParser) ParseJSON(data []byte) error {
+	return this.parseJSON(data, memorySource(ConfigFormatJSON))
+}
+
+// ParseDict sets the arguments from the keys of the dict
+func (this *ArgumentParser) ParseDict(dict *jsonutils.JSONDict) error {
+	return this.parseJSONObject(dict, &configSource{name: memorySource(ConfigFormatJSON)})
+}
+
+// ParseReader sets the arguments from a config document of the format read
+// from r, e.g. stdin or an embedded file
+func (this *ArgumentParser) ParseReader(r io.Reader, format ConfigFormat) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read %s config: %v", format, err)
+	}
+	source := memorySource(format)
+	switch format {
+	case ConfigFormatYAML:
+		return this.parseYAML(content, source)
+	case ConfigFormatJSON:
+		return this.parseJSON(content, source)
+	case ConfigFormatTOML:
+		return this.parseTOML(string(content), source)
+	case ConfigFormatTornado:
+		return this.parseTornado(content, source)
+	}
+	return fmt.Errorf("unsupported config format %q", format)
+}
+
+func (this *ArgumentParser) parseYAML(content []byte, filepath string) error {
+	src := &configSource{name: filepath, content: string(content)}
+	obj, err := jsonutils.ParseYAML(src.content)
+	if err != nil {
+		return src.syntaxError(fmt.Errorf("parse yaml to json object: %v", err))
+	}
+	return this.parseJSONObject(obj, src)
+}
+
+func (this *ArgumentParser) parseJSON(content []byte, filepath string) error {
+	src := &configSource{name: filepath, content: string(content)}
+	obj, err := jsonutils.Parse(content)
 	if err != nil {
-		return fmt.Errorf("parse yaml to json object: %v", err)
+		return src.syntaxError(fmt.Errorf("parse json: %v", err))
 	}
+	return this.parseJSONObject(obj, src)
+}
+
+func (this *ArgumentParser) parseJSONObject(obj jsonutils.JSONObject, src *configSource) error {
 	dict, ok := obj.(*jsonutils.JSONDict)
 	if !ok {
-		return fmt.Errorf("object %s is not JSONDict", obj.String())
+		return &ConfigError{File: src.name, Err: fmt.Errorf("object %s is not JSONDict", obj.String())}
 	}
-	return this.parseJSONDict(dict)
+	includes, err := this.dictIncludes(dict, src)
+	if err != nil {
+		return err
+	}
+	return this.parseWithIncludes(src.name, includes, func() error {
+		return this.parseJSONDict(dict, src)
+	})
+}
+
+func (this *ArgumentParser) parseJSONDict(dict *jsonutils.JSONDict, src *configSource) error {
+	mapJson, err := dict.GetMap()
+	if err != nil {
+		return src.error("", errors.Wrap(err, "GetMap"))
+	}
+	unknown := unknownKeyCollector{strict: this.strictConfig}
+	for key, obj := range mapJson {
+		if this.isIncludeKey(key) {
+			continue
+		}
+		err := unknown.check(this.parseJSONKeyValue(key, obj, src), src.name, 0)
+		if err != nil {
+			return src.error(key, err)
+		}
+		this.setSources(ValueSource{Kind: SourceFile, Name: src.name, Line: src.keyLine(key)})
+	}
+	return unknown.err()
 }
 
-func (this *ArgumentParser) parseJSONDict(dict *jsonutils.JSONDict) error {
+func (this *ArgumentParser) parseNestedJSONDict(parentKey string, dict *jsonutils.JSONDict, src *configSource) error {
 	mapJson, err := dict.GetMap()
 	if err != nil {
-		return errors.Wrap(err, "GetMap")
+		return src.error(parentKey, errors.Wrap(err, "GetMap"))
 	}
+	// unknown keys of all members are reported together
+	unknown := &UnknownConfigKeysError{}
 	for key, obj := range mapJson {
-		if err := this.parseJSONKeyValue(key, obj); err != nil {
-			return fmt.Errorf("parse json %s: %s: %v", key, obj.String(), err)
+		err := this.parseJSONKeyValue(parentKey+"."+key, obj, src)
+		if ukerr, ok := err.(*UnknownConfigKeysError); ok {
+			unknown.Keys = append(unknown.Keys, ukerr.Keys...)
+		} else if err != nil {
+			return src.error(parentKey+"."+key, err)
 		}
 	}
+	if len(unknown.Keys) > 0 {
+		return unknown
+	}
 	return nil
 }
 
@@ -1208,18 +1816,48 @@ func keyToToken(key string) string {
 	return strings.Replace(strings.Trim(key, " "), "_", "-", -1)
 }
 
-func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObject) error {
-	token := keyToToken(key)
-	arg, nega := this.findOptionalArgument(token, true)
+// parseJSONKeyValue sets the argument of the key, keys of nested objects
+// are joined by "."
+func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObject, src *configSource) error {
+	token := keyToToken(strings.Replace(key, ".", "-", -1))
+	arg, nega, _ := this.findOptionalArgument(token, true)
 	if arg == nil {
-		log.Warningf("Cannot find argument %s", token)
-		return nil
+		if dict, ok := obj.(*jsonutils.JSONDict); ok {
+			// object of a nested struct, e.g. {"m": {"non_pos": "x"}} for
+			// argument m-non-pos
+			return this.parseNestedJSONDict(key, dict, src)
+		}
+		return &UnknownConfigKeysError{Keys: []UnknownConfigKey{{Key: key, File: src.name, Line: src.keyLine(key)}}}
 	}
 	if nega {
-		log.Warningf("Ignore negative token when parse JSONKeyValue %s", token)
+		log.Warningf("%s: Ignore negative token when parse JSONKeyValue %s", src.position(src.keyLine(key)), token)
+		return nil
+	}
+	if !this.overrideArgument(arg) {
 		return nil
 	}
-	if arg.IsSet() {
+	if err := this.setJSONValue(arg, obj); err != nil {
+		return src.error(key, err)
+	}
+	return nil
+}
+
+func (this *ArgumentParser) setJSONValue(arg Argument, obj jsonutils.JSONObject) error {
+	// process map argument written as a mapping
+	if dict, ok := obj.(*jsonutils.JSONDict); ok && argIsMap(arg) {
+		mapJson, err := dict.GetMap()
+		if err != nil {
+			return errors.Wrap(err, "GetMap")
+		}
+		for k, v := range mapJson {
+			str, err := v.GetString()
+			if err != nil {
+				return err
+			}
+			if err := this.setConfigValue(arg, k+"="+str); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 	// process multi argument
@@ -1233,7 +1871,7 @@ func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObje
 			if err != nil {
 				return err
 			}
-			if err := arg.SetValue(str); err != nil {
+			if err := this.setConfigValue(arg, str); err != nil {
 				return err
 			}
 		}
@@ -1244,19 +1882,67 @@ func (this *ArgumentParser) parseJSONKeyValue(key string, obj jsonutils.JSONObje
 	if err != nil {
 		return err
 	}
-	return arg.SetValue(str)
+	return this.setConfigValue(arg, str)
 }
 
+// ParseFile sets the arguments from a config file, the format is chosen by
+// the file extension.  Files of other extensions are parsed as YAML if the
+// content is a YAML mapping, otherwise as tornado style.  The files listed
+// by the "include" or "includes" key are parsed as well.  The file is
+// parsed again by Reload.
 func (this *ArgumentParser) ParseFile(filepath string) error {
-	if err := this.ParseYAMLFile(filepath); err == nil {
-		return nil
+	this.addReloadLayer(filepath, FileLayer(filepath))
+	return this.parseFile(filepath)
+}
+
+func (this *ArgumentParser) parseFile(filepath string) error {
+	this.addConfigFile(filepath)
+	switch strings.ToLower(path.Ext(filepath)) {
+	case ".toml":
+		return this.ParseTOMLFile(filepath)
+	case ".json":
+		return this.ParseJSONFile(filepath)
+	case ".yaml", ".yml":
+		return this.ParseYAMLFile(filepath)
 	}
-	return this.ParseTornadoFile(filepath)
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("read file %s: %v", filepath, err)
+	}
+	if obj, err := jsonutils.ParseYAML(string(content)); err == nil {
+		if dict, ok := obj.(*jsonutils.JSONDict); ok {
+			err := this.parseJSONObject(dict, &configSource{name: filepath, content: string(content)})
+			if err == nil || this.strictConfig {
+				// the errors of the keys of a YAML mapping are reported in
+				// strict mode
+				return err
+			}
+			// fall back to the tornado style, e.g. "key: value" lines are
+			// a YAML mapping too, the YAML errors are more relevant if both
+			// fail
+			if terr := this.parseTornado(content, filepath); terr != nil {
+				return err
+			}
+			return nil
+		}
+	}
+	return this.parseTornado(content, filepath)
+}
+
+func (this *ArgumentParser) parseTornado(content []byte, filepath string) error {
+	return this.parseWithIncludes(filepath, this.tornadoIncludes(content), func() error {
+		return this.parseReader(bytes.NewReader(content), filepath)
+	})
 }
 
-func (this *ArgumentParser) parseReader(r io.Reader) error {
+func (this *ArgumentParser) parseReader(r io.Reader, filepath string) error {
 	scanner := bufio.NewScanner(r)
+	unknown := unknownKeyCollector{strict: this.strictConfig}
+	// invalid values, the other lines are still parsed unless failFast
+	errs := make([]error, 0)
+	lineNo := 0
 	for scanner.Scan() {
+		lineNo++
 		line := scanner.Text()
 		line = strings.TrimSpace(removeComments(line))
 		// line = removeCharacters(line, `"'`)
@@ -1265,29 +1951,38 @@ func (this *ArgumentParser) parseReader(r io.Reader) error {
 				continue
 			}
 			key, val, e := line2KeyValue(line)
+			if e == nil && this.isIncludeKey(key) {
+				continue
+			}
 			if e == nil {
-				this.parseKeyValue(key, val)
+				e = unknown.check(this.parseKeyValue(key, val, configPosition(filepath, lineNo)), filepath, lineNo)
+				if e != nil {
+					e = &ConfigError{File: filepath, Line: lineNo, Key: key, Err: e}
+					if this.failFast {
+						return e
+					}
+					errs = append(errs, e)
+				}
+				this.setSources(ValueSource{Kind: SourceFile, Name: filepath, Line: lineNo})
 			} else {
-				return e
+				return &ConfigError{File: filepath, Line: lineNo, Column: 1, Err: e}
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return err
+		return &ConfigError{File: filepath, Line: lineNo, Err: err}
 	}
 
-	return nil
+	return joinErrors(append(errs, unknown.err()))
 }
 
 func (this *ArgumentParser) ParseTornadoFile(filepath string) error {
-	file, e := os.Open(filepath)
+	content, e := ioutil.ReadFile(filepath)
 	if e != nil {
 		return e
 	}
-	defer file.Close()
-
-	return this.parseReader(file)
+	return this.parseTornado(content, filepath)
 }
 
 func (this *ArgumentParser) GetSubcommand() *SubcommandArgument {
@@ -1315,3 +2010,11 @@ func (this *ArgumentParser) GetPosArgs() []Argument {
 func (this *ArgumentParser) IsHelpSet() bool {
 	return this.help
 }
+
+// RemainingArgs returns the arguments after the "--" terminator untouched,
+// e.g. the command to be passed through by ssh or exec wrappers.  The
+// arguments also feed the positional arguments, those exceeding the
+// positional arguments are not considered unknown.
+func (this *ArgumentParser) RemainingArgs() []string {
+	return this.remainingArgs
+}
@@ -231,7 +231,7 @@ func TestBoolField(t *testing.T) {
 		p := mustNewParser(t, s)
 		args := []string{
 			"--bool",
-			"--bool-p",
+			"--bool-ptr",
 			"--bool-default-true",
 			"--bool-ptr-default-true",
 			"--bool-default-false",
@@ -254,7 +254,7 @@ func TestBoolField(t *testing.T) {
 		r := bytes.NewBufferString(`
 bool_default_true = False
                `)
-		if err := p.parseReader(r); err != nil {
+		if err := p.parseReader(r, ""); err != nil {
 			t.Fatalf("parse reader: %v", err)
 		}
 		if s.BoolDefaultTrue {
@@ -401,6 +401,322 @@ func TestArgValue(t *testing.T) {
 	}
 }
 
+func TestInlineValue(t *testing.T) {
+	s := &struct {
+		Port     int    `short-token:"p"`
+		AuthURL  string `alias:"auth-uri"`
+		Labels   map[string]string
+		Networks []string
+		Debug    bool `negative:"no-debug"`
+		Verbose  bool `short-token:"v"`
+	}{}
+	p := mustNewParser(t, s)
+	t.Run("values", func(t *testing.T) {
+		args := []string{
+			"--port=8080",
+			"--auth-uri=http://127.0.0.1:5000/v3?a=b",
+			"--labels=k1=v1",
+			"--labels", "k2=v2",
+			"--networks=net1",
+			"--networks=",
+		}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Port != 8080 {
+			t.Errorf("port: want 8080, got %d", s.Port)
+		}
+		if want := "http://127.0.0.1:5000/v3?a=b"; s.AuthURL != want {
+			t.Errorf("auth url: want %s, got %s", want, s.AuthURL)
+		}
+		if want := map[string]string{"k1": "v1", "k2": "v2"}; !reflect.DeepEqual(s.Labels, want) {
+			t.Errorf("labels: want %v, got %v", want, s.Labels)
+		}
+		if want := []string{"net1", ""}; !reflect.DeepEqual(s.Networks, want) {
+			t.Errorf("networks: want %#v, got %#v", want, s.Networks)
+		}
+	})
+	t.Run("short token", func(t *testing.T) {
+		if err := p.ParseArgs([]string{"-p=22", "-v=true"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Port != 22 || !s.Verbose {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("bool", func(t *testing.T) {
+		cases := []struct {
+			arg  string
+			want bool
+		}{
+			{"--debug=true", true},
+			{"--debug=false", false},
+			{"--debug=1", true},
+			{"--no-debug=true", false},
+			{"--no-debug=false", true},
+		}
+		for _, c := range cases {
+			s.Debug = !c.want
+			if err := p.ParseArgs([]string{c.arg}, false); err != nil {
+				t.Fatalf("%s: ParseArgs failed: %s", c.arg, err)
+			}
+			if s.Debug != c.want {
+				t.Errorf("%s: want %v, got %v", c.arg, c.want, s.Debug)
+			}
+		}
+		if err := p.ParseArgs([]string{"--debug=maybe"}, false); err == nil {
+			t.Errorf("expecting error for invalid boolean value")
+		}
+	})
+}
+
+func TestShortCluster(t *testing.T) {
+	type opts struct {
+		Verbose bool   `short-token:"v"`
+		Debug   bool   `short-token:"d"`
+		Force   bool   `short-token:"f"`
+		Port    int    `short-token:"p"`
+		Output  string `short-token:"o"`
+	}
+	cases := []struct {
+		name string
+		args []string
+		want opts
+	}{
+		{
+			name: "combined booleans",
+			args: []string{"-vdf"},
+			want: opts{Verbose: true, Debug: true, Force: true},
+		},
+		{
+			name: "attached value",
+			args: []string{"-p8080"},
+			want: opts{Port: 8080},
+		},
+		{
+			name: "separate value",
+			args: []string{"-p", "8080"},
+			want: opts{Port: 8080},
+		},
+		{
+			name: "booleans then value",
+			args: []string{"-vdp", "22", "-ofile.txt"},
+			want: opts{Verbose: true, Debug: true, Port: 22, Output: "file.txt"},
+		},
+		{
+			name: "booleans then attached value",
+			args: []string{"-vo=out=1"},
+			want: opts{Verbose: true, Output: "out=1"},
+		},
+		{
+			name: "long token with single dash",
+			args: []string{"-debug", "-port", "80"},
+			want: opts{Debug: true, Port: 80},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &opts{}
+			p := mustNewParser(t, s)
+			if err := p.ParseArgs(c.args, false); err != nil {
+				t.Fatalf("ParseArgs failed: %s", err)
+			}
+			if *s != c.want {
+				t.Errorf("want %#v, got %#v", c.want, *s)
+			}
+		})
+	}
+	t.Run("unknown in cluster", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		if err := p.ParseArgs([]string{"-vx"}, false); err == nil {
+			t.Errorf("expecting error")
+		}
+	})
+	t.Run("missing value", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		if err := p.ParseArgs([]string{"-vp"}, false); err == nil {
+			t.Errorf("expecting error")
+		}
+	})
+	t.Run("usage", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		arg, _, _ := p.findOptionalArgument("port", true)
+		if want := "[-p|--port PORT]"; arg.String() != want {
+			t.Errorf("want %s, got %s", want, arg.String())
+		}
+	})
+}
+
+func TestOptionsTerminator(t *testing.T) {
+	t.Run("positionals", func(t *testing.T) {
+		s := &struct {
+			Debug bool
+			NUM   string
+			FILES []string
+		}{}
+		p := mustNewParser(t, s)
+		args := []string{"--debug", "--", "-1", "-file", "--help", "--"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Debug || s.NUM != "-1" || !reflect.DeepEqual(s.FILES, []string{"-file", "--help", "--"}) {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+		if p.IsHelpSet() {
+			t.Errorf("--help after -- should not be an option")
+		}
+		if want := args[2:]; !reflect.DeepEqual(p.RemainingArgs(), want) {
+			t.Errorf("remaining args: want %#v, got %#v", want, p.RemainingArgs())
+		}
+	})
+	t.Run("pass through", func(t *testing.T) {
+		type execOptions struct {
+			User string
+			HOST string
+		}
+		s := &struct {
+			SUBCOMMAND string `subcommand:"true"`
+		}{}
+		p := mustNewParser(t, s)
+		subp, err := p.GetSubcommand().AddSubParser(&execOptions{}, "exec", "Run a command", func(opts *execOptions) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("AddSubParser: %v", err)
+		}
+		args := []string{"exec", "--user", "root", "host", "--", "ls", "-l", "--user"}
+		if err := p.ParseArgs(args, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		opts := subp.Options().(*execOptions)
+		if opts.User != "root" || opts.HOST != "host" {
+			t.Errorf("wrong parse result: %#v", opts)
+		}
+		if want := []string{"ls", "-l", "--user"}; !reflect.DeepEqual(subp.RemainingArgs(), want) {
+			t.Errorf("remaining args: want %#v, got %#v", want, subp.RemainingArgs())
+		}
+		if err := p.ParseArgs([]string{"exec", "host"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if len(subp.RemainingArgs()) != 0 {
+			t.Errorf("remaining args should be reset, got %#v", subp.RemainingArgs())
+		}
+	})
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	newSubcommand := func(t *testing.T) *SubcommandArgument {
+		s := &struct {
+			SUBCOMMAND string `subcommand:"true"`
+		}{}
+		subcmd := mustNewParser(t, s).GetSubcommand()
+		callback := func(opts *struct{}) error { return nil }
+		for _, cmd := range []struct {
+			name     string
+			category string
+		}{
+			{"server-list", "Servers"},
+			{"version", ""},
+			{"network-show", "Networks"},
+			{"server-create", "Servers"},
+			{"network-list", "Networks"},
+			{"help", ""},
+		} {
+			_, err := subcmd.AddSubParserWithCategory(&struct{}{}, cmd.name, cmd.name+" desc", cmd.category, callback)
+			if err != nil {
+				t.Fatalf("AddSubParserWithCategory: %v", err)
+			}
+		}
+		return subcmd
+	}
+	t.Run("registration", func(t *testing.T) {
+		want := "" +
+			"  version\n    version desc\n" +
+			"  help\n    help desc\n" +
+			"  Servers:\n" +
+			"    server-list\n      server-list desc\n" +
+			"    server-create\n      server-create desc\n" +
+			"  Networks:\n" +
+			"    network-show\n      network-show desc\n" +
+			"    network-list\n      network-list desc\n"
+		subcmd := newSubcommand(t)
+		for i := 0; i < 5; i++ {
+			if got := subcmd.HelpString("  "); got != want {
+				t.Fatalf("want\n%s\ngot\n%s", want, got)
+			}
+		}
+	})
+	t.Run("alphabetical", func(t *testing.T) {
+		want := "" +
+			"  help\n    help desc\n" +
+			"  version\n    version desc\n" +
+			"  Networks:\n" +
+			"    network-list\n      network-list desc\n" +
+			"    network-show\n      network-show desc\n" +
+			"  Servers:\n" +
+			"    server-create\n      server-create desc\n" +
+			"    server-list\n      server-list desc\n"
+		subcmd := newSubcommand(t)
+		subcmd.SetSubcommandOrder(SubcommandOrderAlphabetical)
+		if got := subcmd.HelpString("  "); got != want {
+			t.Errorf("want\n%s\ngot\n%s", want, got)
+		}
+	})
+}
+
+func TestAbbreviation(t *testing.T) {
+	type opts struct {
+		Admin        bool
+		AdminUser    string
+		AdminDomain  string
+		AdminProject string `alias:"admin-tenant-name"`
+		Region       string
+	}
+	t.Run("ambiguous", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		err := p.ParseArgs([]string{"--admin-", "x"}, false)
+		ambErr, ok := err.(*AmbiguousArgumentError)
+		if !ok {
+			t.Fatalf("want AmbiguousArgumentError, got %v", err)
+		}
+		want := []string{"--admin-domain", "--admin-project", "--admin-user"}
+		if !reflect.DeepEqual(ambErr.Candidates, want) {
+			t.Errorf("candidates: want %v, got %v", want, ambErr.Candidates)
+		}
+	})
+	t.Run("exact match wins", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--admin", "--admin-u", "user"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if !s.Admin || s.AdminUser != "user" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("unique prefix", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		if err := p.ParseArgs([]string{"--reg", "region0", "--admin-t", "system"}, false); err != nil {
+			t.Fatalf("ParseArgs failed: %s", err)
+		}
+		if s.Region != "region0" || s.AdminProject != "system" {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("disallow abbreviation", func(t *testing.T) {
+		s := &opts{}
+		p := mustNewParser(t, s)
+		p.SetAllowAbbrev(false)
+		if err := p.ParseArgs([]string{"--reg", "region0"}, false); err == nil {
+			t.Errorf("expecting error for abbreviated token")
+		}
+		if err := p.ParseArgs([]string{"--region", "region0"}, false); err != nil {
+			t.Errorf("ParseArgs failed: %s", err)
+		}
+	})
+}
+
 func TestIgnoreUnexported(t *testing.T) {
 	s := &struct {
 		unexported string
@@ -482,6 +798,20 @@ func TestStructMember(t *testing.T) {
 	})
 }
 
+type nestedJSONMember struct {
+	NonPos string
+	Ports  map[string]int
+	DB     struct {
+		SqlConnection string
+	}
+}
+
+type nestedJSONOptions struct {
+	Name   string
+	M      nestedJSONMember
+	Labels map[string]string
+}
+
 func TestArgumentParser_parseJSONDict(t *testing.T) {
 	toJSONDict := func(input map[string]interface{}) *jsonutils.JSONDict {
 		return jsonutils.Marshal(input).(*jsonutils.JSONDict)
@@ -526,6 +856,44 @@ func TestArgumentParser_parseJSONDict(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:        "parse nested json dict",
+			inputTarget: &nestedJSONOptions{},
+			args: toJSONDict(map[string]interface{}{
+				"name": "args",
+				"m": map[string]interface{}{
+					"non_pos": "x",
+					"ports":   map[string]interface{}{"http": 80, "https": 443},
+					"db": map[string]interface{}{
+						"sql_connection": "mysql://localhost",
+					},
+				},
+				"labels": map[string]string{"a": "1", "b": "2"},
+			}),
+			wantTarget: &nestedJSONOptions{
+				Name: "args",
+				M: nestedJSONMember{
+					NonPos: "x",
+					Ports:  map[string]int{"http": 80, "https": 443},
+					DB: struct {
+						SqlConnection string
+					}{SqlConnection: "mysql://localhost"},
+				},
+				Labels: map[string]string{"a": "1", "b": "2"},
+			},
+			wantErr: false,
+		},
+		{
+			name:        "parse invalid nested json dict",
+			inputTarget: &nestedJSONOptions{},
+			args: toJSONDict(map[string]interface{}{
+				"m": map[string]interface{}{
+					"ports": map[string]interface{}{"http": "x"},
+				},
+			}),
+			wantTarget: &nestedJSONOptions{},
+			wantErr:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -534,7 +902,7 @@ func TestArgumentParser_parseJSONDict(t *testing.T) {
 				t.Errorf("newParser: %v", err)
 				return
 			}
-			if err := parser.parseJSONDict(tt.args); (err != nil) != tt.wantErr {
+			if err := parser.parseJSONDict(tt.args, &configSource{}); (err != nil) != tt.wantErr {
 				t.Errorf("ArgumentParser.parseJSONDict() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if !reflect.DeepEqual(tt.inputTarget, tt.wantTarget) {
@@ -546,6 +914,104 @@ func TestArgumentParser_parseJSONDict(t *testing.T) {
 	}
 }
 
+func TestParseInMemory(t *testing.T) {
+	type opts struct {
+		Region   string
+		Port     int
+		Networks []string
+		Debug    bool
+	}
+	want := opts{Region: "region0", Port: 8080, Networks: []string{"net1", "net2"}, Debug: true}
+	cases := []struct {
+		name  string
+		parse func(p *ArgumentParser) error
+	}{
+		{
+			name: "json",
+			parse: func(p *ArgumentParser) error {
+				return p.ParseJSON([]byte(`{"region": "region0", "port": 8080, "networks": ["net1", "net2"], "debug": true}`))
+			},
+		},
+		{
+			name: "yaml",
+			parse: func(p *ArgumentParser) error {
+				return p.ParseYAML([]byte("region: region0\nport: 8080\nnetworks:\n- net1\n- net2\ndebug: true\n"))
+			},
+		},
+		{
+			name: "dict",
+			parse: func(p *ArgumentParser) error {
+				dict := jsonutils.NewDict()
+				dict.Set("region", jsonutils.NewString("region0"))
+				dict.Set("port", jsonutils.NewInt(8080))
+				dict.Set("networks", jsonutils.NewStringArray([]string{"net1", "net2"}))
+				dict.Set("debug", jsonutils.JSONTrue)
+				return p.ParseDict(dict)
+			},
+		},
+		{
+			name: "toml reader",
+			parse: func(p *ArgumentParser) error {
+				r := bytes.NewBufferString("region = 'region0'\nport = 8080\nnetworks = ['net1', 'net2']\ndebug = true\n")
+				return p.ParseReader(r, ConfigFormatTOML)
+			},
+		},
+		{
+			name: "tornado reader",
+			parse: func(p *ArgumentParser) error {
+				r := bytes.NewBufferString("region = 'region0'\nport = 8080\nnetworks = ['net1', 'net2']\ndebug = True\n")
+				return p.ParseReader(r, ConfigFormatTornado)
+			},
+		},
+		{
+			name: "json reader",
+			parse: func(p *ArgumentParser) error {
+				r := bytes.NewBufferString(`{"region": "region0", "port": 8080, "networks": ["net1", "net2"], "debug": true}`)
+				return p.ParseReader(r, ConfigFormatJSON)
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &opts{}
+			p := mustNewParser(t, s)
+			if err := c.parse(p); err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if !reflect.DeepEqual(*s, want) {
+				t.Errorf("want %#v, got %#v", want, *s)
+			}
+			if src := p.Source("region"); src.Kind != SourceFile || !strings.HasPrefix(src.Name, "<") {
+				t.Errorf("wrong source %s", src)
+			}
+		})
+	}
+	t.Run("nested yaml", func(t *testing.T) {
+		s := &nestedJSONOptions{}
+		p := mustNewParser(t, s)
+		err := p.ParseYAML([]byte("m:\n  non_pos: x\n  db:\n    sql_connection: mysql://localhost\nlabels:\n  a: '1'\n  b: '2'\n"))
+		if err != nil {
+			t.Fatalf("ParseYAML: %v", err)
+		}
+		if s.M.NonPos != "x" || s.M.DB.SqlConnection != "mysql://localhost" ||
+			!reflect.DeepEqual(s.Labels, map[string]string{"a": "1", "b": "2"}) {
+			t.Errorf("wrong parse result: %#v", s)
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		p := mustNewParser(t, &opts{})
+		if err := p.ParseJSON([]byte(`["region"]`)); err == nil {
+			t.Errorf("expecting error for non-dict json")
+		}
+		if err := p.ParseJSON([]byte(`{"region":`)); err == nil {
+			t.Errorf("expecting error for malformed json")
+		}
+		if err := p.ParseReader(bytes.NewBufferString(""), ConfigFormat("ini")); err == nil {
+			t.Errorf("expecting error for unsupported format")
+		}
+	})
+}
+
 func Test_keyToToken(t *testing.T) {
 	type args struct {
 		key string
@@ -574,3 +1040,24 @@ func Test_keyToToken(t *testing.T) {
 		})
 	}
 }
+
+func Test_removeComments(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"port = 8080 # listen port", "port = 8080 "},
+		{"# comment", ""},
+		{"name = a#b", "name = a"},
+		{"name = 'a#b' # comment", "name = 'a#b' "},
+		{`name = "a#b"`, `name = "a#b"`},
+		{`password = 'p@ss\'word#1'`, `password = 'p@ss\'word#1'`},
+		{"hosts = ['a#1', \"b#2\"] # hosts", "hosts = ['a#1', \"b#2\"] "},
+		{"name = it's#1", "name = it's"},
+	}
+	for _, tt := range tests {
+		if got := removeComments(tt.line); got != tt.want {
+			t.Errorf("removeComments(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
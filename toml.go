@@ -0,0 +1,376 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"yunion.io/x/log"
+	"yunion.io/x/pkg/errors"
+)
+
+// tomlEntry is a key/value pair of a TOML document, the key is the full
+// dotted path including the table, values of inline tables are flattened
+// into entries
+type tomlEntry struct {
+	key   []string
+	value interface{}
+	line  int
+}
+
+type tomlError struct {
+	Line int
+	Msg  string
+}
+
+func (e *tomlError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// parseTOML decodes a TOML document into its key/value pairs in document
+// order.  Arrays of tables are not supported.
+func parseTOML(data string) ([]tomlEntry, error) {
+	doc := make(map[string]interface{})
+	md, err := toml.Decode(data, &doc)
+	if err != nil {
+		if perr, ok := err.(toml.ParseError); ok {
+			return nil, &tomlError{Line: perr.Line, Msg: perr.Message}
+		}
+		return nil, err
+	}
+	lines := newTOMLKeyLines(data)
+	entries := make([]tomlEntry, 0)
+	for _, key := range md.Keys() {
+		switch md.Type(key...) {
+		case "Hash":
+			// the members of tables are listed as keys
+			continue
+		case "ArrayHash":
+			return nil, &tomlError{Line: lines.line(key), Msg: fmt.Sprintf("array of tables %s is not supported", key)}
+		}
+		value, ok := tomlLookup(doc, key)
+		if !ok {
+			return nil, &tomlError{Line: lines.line(key), Msg: fmt.Sprintf("table in array %s is not supported", key)}
+		}
+		entries = append(entries, tomlEntry{key: key, value: value, line: lines.line(key)})
+	}
+	return entries, nil
+}
+
+// tomlLookup returns the value of the key in the decoded document
+func tomlLookup(doc map[string]interface{}, key []string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, k := range key {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[k]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// tomlKeyLines maps the keys of a TOML document to the lines defining
+// them, which are not reported by the decoder
+type tomlKeyLines map[string]int
+
+func newTOMLKeyLines(data string) tomlKeyLines {
+	lines := make(tomlKeyLines)
+	var table []string
+	multiline := ""
+	for i, line := range strings.Split(data, "\n") {
+		if len(multiline) > 0 {
+			// inside a multi-line string
+			if strings.Count(line, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			table, _ = scanTOMLKey(strings.TrimLeft(trimmed, "["))
+			lines.add(table, i+1)
+			continue
+		}
+		key, rest := scanTOMLKey(trimmed)
+		rest = strings.TrimSpace(rest)
+		if len(key) == 0 || !strings.HasPrefix(rest, "=") {
+			continue
+		}
+		lines.add(append(append([]string{}, table...), key...), i+1)
+		for _, delim := range []string{`"""`, `'''`} {
+			if strings.Count(rest, delim)%2 == 1 {
+				multiline = delim
+			}
+		}
+	}
+	return lines
+}
+
+func (l tomlKeyLines) add(key []string, line int) {
+	k := strings.Join(key, "\x00")
+	if _, ok := l[k]; !ok {
+		l[k] = line
+	}
+}
+
+// line returns the line of the key, or of the closest table or inline table
+// containing it, 0 if not found
+func (l tomlKeyLines) line(key []string) int {
+	for n := len(key); n > 0; n-- {
+		if line, ok := l[strings.Join(key[:n], "\x00")]; ok {
+			return line
+		}
+	}
+	return 0
+}
+
+// scanTOMLKey scans the bare, quoted or dotted key at the start of str,
+// returns the parts of the key and the rest of str
+func scanTOMLKey(str string) ([]string, string) {
+	parts := make([]string, 0)
+	for {
+		str = strings.TrimLeft(str, " \t")
+		if len(str) == 0 {
+			return parts, str
+		}
+		var part string
+		switch str[0] {
+		case '"':
+			end := 1
+			for end < len(str) && str[end] != '"' {
+				if str[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(str) {
+				return parts, str
+			}
+			part, _ = strconv.Unquote(str[:end+1])
+			str = str[end+1:]
+		case '\'':
+			end := strings.IndexByte(str[1:], '\'')
+			if end < 0 {
+				return parts, str
+			}
+			part = str[1 : end+1]
+			str = str[end+2:]
+		default:
+			end := 0
+			for end < len(str) && isBareKeyChar(str[end]) {
+				end++
+			}
+			if end == 0 {
+				return parts, str
+			}
+			part = str[:end]
+			str = str[end:]
+		}
+		parts = append(parts, part)
+		str = strings.TrimLeft(str, " \t")
+		if !strings.HasPrefix(str, ".") {
+			return parts, str
+		}
+		str = str[1:]
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// tomlValueString returns the textual form of a scalar value set to an
+// argument of the kind.  TOML strings are parsed like the values of other
+// config formats, the values of other TOML types must match the kind.
+func tomlValueString(value interface{}, kind reflect.Kind) (string, error) {
+	var str, typeName string
+	var match bool
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		str, typeName = strconv.FormatBool(v), "boolean"
+		match = kind == reflect.Bool
+	case int64:
+		str, typeName = strconv.FormatInt(v, 10), "integer"
+		match = isIntKind(kind) || isUintKind(kind) || isFloatKind(kind)
+	case float64:
+		str, typeName = strconv.FormatFloat(v, 'g', -1, 64), "float"
+		match = isFloatKind(kind)
+	case time.Time:
+		str, typeName = formatTOMLDatetime(v), "datetime"
+		match = kind == reflect.Struct
+	case []interface{}:
+		return "", fmt.Errorf("unexpected array")
+	case map[string]interface{}, []map[string]interface{}:
+		return "", fmt.Errorf("unexpected table")
+	default:
+		return "", fmt.Errorf("unexpected value %v", value)
+	}
+	if !match && kind != reflect.String && kind != reflect.Interface && kind != reflect.Invalid {
+		return "", fmt.Errorf("expect a %s value, got %s %s", kind, typeName, str)
+	}
+	return str, nil
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uintptr
+}
+
+func isFloatKind(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
+}
+
+// formatTOMLDatetime formats a decoded offset or local date-time, date or
+// time in the TOML syntax
+func formatTOMLDatetime(t time.Time) string {
+	switch t.Location().String() {
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	case "date-local":
+		return t.Format("2006-01-02")
+	case "time-local":
+		return t.Format("15:04:05.999999999")
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// argValueKind returns the kind of the values of the argument, i.e. the
+// elements of arrays and maps, reflect.Invalid if unknown
+func argValueKind(arg Argument) reflect.Kind {
+	valArg, ok := arg.(interface {
+		getValue() reflect.Value
+	})
+	if !ok {
+		return reflect.Invalid
+	}
+	typ := valArg.getValue().Type()
+	for {
+		switch typ.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			typ = typ.Elem()
+			continue
+		}
+		return typ.Kind()
+	}
+}
+
+func (this *ArgumentParser) ParseTOMLFile(filepath string) error {
+	content, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("read file %s: %v", filepath, err)
+	}
+	return this.parseTOML(string(content), filepath)
+}
+
+// parseTOML sets the arguments from a TOML document, tables map to the
+// token prefixes of nested structs, e.g. key "non_pos" of table "[m]" sets
+// argument "m-non-pos", and to the keys of map arguments
+func (this *ArgumentParser) parseTOML(content string, filepath string) error {
+	entries, err := parseTOML(content)
+	if err != nil {
+		if terr, ok := err.(*tomlError); ok {
+			return &ConfigError{File: filepath, Line: terr.Line, Err: errors.Error(terr.Msg)}
+		}
+		return &ConfigError{File: filepath, Err: err}
+	}
+	includes, err := this.tomlIncludes(entries, filepath)
+	if err != nil {
+		return err
+	}
+	return this.parseWithIncludes(filepath, includes, func() error {
+		return this.setTOMLEntries(entries, filepath)
+	})
+}
+
+func (this *ArgumentParser) setTOMLEntries(entries []tomlEntry, filepath string) error {
+	// map arguments are set by several entries
+	claimed := make(map[Argument]bool)
+	unknown := unknownKeyCollector{strict: this.strictConfig}
+	for _, entry := range entries {
+		if len(entry.key) == 1 && this.isIncludeKey(entry.key[0]) {
+			continue
+		}
+		err := unknown.check(this.setTOMLValue(entry, claimed, filepath), filepath, entry.line)
+		if err != nil {
+			return &ConfigError{File: filepath, Line: entry.line, Key: strings.Join(entry.key, "."), Err: err}
+		}
+		this.setSources(ValueSource{Kind: SourceFile, Name: filepath, Line: entry.line})
+	}
+	return unknown.err()
+}
+
+func (this *ArgumentParser) setTOMLValue(entry tomlEntry, claimed map[Argument]bool, filepath string) error {
+	tokens := make([]string, len(entry.key))
+	for i := range entry.key {
+		tokens[i] = keyToToken(entry.key[i])
+	}
+	// the longest prefix of the key matching an argument, the rest of the
+	// key is the key of a map argument
+	for n := len(tokens); n > 0; n-- {
+		arg, nega, _ := this.findOptionalArgument(strings.Join(tokens[:n], "-"), true)
+		if arg == nil {
+			continue
+		}
+		if n < len(tokens) && !argIsMap(arg) {
+			break
+		}
+		if nega {
+			log.Warningf("%s: Ignore negative token when parse %s", configPosition(filepath, entry.line), strings.Join(entry.key, "."))
+			return nil
+		}
+		if !claimed[arg] {
+			if !this.overrideArgument(arg) {
+				return nil
+			}
+			claimed[arg] = true
+		}
+		mapKey := ""
+		if n < len(tokens) {
+			mapKey = strings.Join(entry.key[n:], ".") + "="
+		}
+		values, isArray := entry.value.([]interface{})
+		if !isArray {
+			values = []interface{}{entry.value}
+		} else if !arg.IsMulti() {
+			return fmt.Errorf("expect a single value, got an array")
+		}
+		for _, value := range values {
+			str, err := tomlValueString(value, argValueKind(arg))
+			if err != nil {
+				return err
+			}
+			if err := this.setConfigValue(arg, mapKey+str); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return unknownConfigKeyErr(strings.Join(entry.key, "."))
+}
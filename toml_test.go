@@ -0,0 +1,229 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTOMLDocument(t *testing.T) {
+	doc := `# comment
+title = "TOML \"example\"\t\u00e9" # trailing comment
+literal = 'C:\Users\path'
+int = +1_000
+hex = 0xff
+neg = -17
+float = 6.626e-34
+pi = 3.14
+inf = -inf
+bool = true
+date = 1979-05-27T07:32:00Z
+date2 = 1979-05-27 07:32:00
+time = 07:32:00
+"quoted key" = 1
+site."google.com" = true
+multi = """
+Roses are red \
+   Violets are blue"""
+multi_lit = '''
+line1
+line2'''
+array = [ 1, 2,
+  3, # comment
+]
+nested = [[1, 2], ["a"]]
+
+[server.http]
+port = 8080
+labels = { a = "1", b.c = 2 }
+`
+	entries, err := parseTOML(doc)
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	got := make(map[string]interface{})
+	lines := make(map[string]int)
+	for _, e := range entries {
+		key := strings.Join(e.key, "|")
+		got[key] = e.value
+		if dt, ok := e.value.(time.Time); ok {
+			got[key] = formatTOMLDatetime(dt)
+		}
+		lines[key] = e.line
+	}
+	want := map[string]interface{}{
+		"title":                  "TOML \"example\"\t\u00e9",
+		"literal":                `C:\Users\path`,
+		"int":                    int64(1000),
+		"hex":                    int64(255),
+		"neg":                    int64(-17),
+		"float":                  6.626e-34,
+		"pi":                     3.14,
+		"inf":                    math.Inf(-1),
+		"bool":                   true,
+		"date":                   "1979-05-27T07:32:00Z",
+		"date2":                  "1979-05-27T07:32:00",
+		"time":                   "07:32:00",
+		"quoted key":             int64(1),
+		"site|google.com":        true,
+		"multi":                  "Roses are red Violets are blue",
+		"multi_lit":              "line1\nline2",
+		"array":                  []interface{}{int64(1), int64(2), int64(3)},
+		"nested":                 []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{"a"}},
+		"server|http|port":       int64(8080),
+		"server|http|labels|a":   "1",
+		"server|http|labels|b|c": int64(2),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %#v\ngot %#v", want, got)
+	}
+	if lines["server|http|port"] != 28 || lines["server|http|labels|b|c"] != 29 {
+		t.Errorf("wrong lines: %v", lines)
+	}
+
+	for _, bad := range []string{
+		"a = ",
+		"a = 1\na = 2",
+		"a = \"unterminated",
+		"a = 1 b = 2",
+		"[[servers]]\nname = 'a'",
+		"[server]\nname = 'a'\n[server]\nport = 1",
+		"x = [{ a = 1 }]",
+		"a = [1, 2",
+		"a = \"\\x\"",
+		"= 1",
+	} {
+		if _, err := parseTOML(bad); err == nil {
+			t.Errorf("%q: expecting error", bad)
+		}
+	}
+}
+
+func TestParseTOMLFile(t *testing.T) {
+	type opts struct {
+		Region   string
+		Port     int
+		Debug    bool
+		Ratio    float64
+		Networks []string
+		Ports    []int
+		Labels   map[string]string
+		Started  string
+		Server   struct {
+			Name    string
+			Timeout int
+		}
+	}
+	dir, err := ioutil.TempDir("", "structarg")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	conf := writeTestFile(t, dir, "region.toml", `
+region = "region0"
+port = 8080
+debug = true
+ratio = 0.5
+networks = ["net1", "net2"]
+ports = [80, 443]
+started = 2019-06-20T10:41:49Z
+unknown_key = 1
+
+[labels]
+a = "1"
+"b.c" = "2"
+
+[server]
+name = "server0"
+timeout = 30
+`)
+	s := &opts{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs2([]string{"--port", "22"}, false, false); err != nil {
+		t.Fatalf("ParseArgs2: %v", err)
+	}
+	if err := p.ParseFile(conf); err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	want := opts{
+		Region:   "region0",
+		Port:     22,
+		Debug:    true,
+		Ratio:    0.5,
+		Networks: []string{"net1", "net2"},
+		Ports:    []int{80, 443},
+		Labels:   map[string]string{"a": "1", "b.c": "2"},
+		Started:  "2019-06-20T10:41:49Z",
+	}
+	want.Server.Name = "server0"
+	want.Server.Timeout = 30
+	if !reflect.DeepEqual(*s, want) {
+		t.Errorf("want %#v, got %#v", want, *s)
+	}
+	if src := p.Source("server-timeout"); src.Kind != SourceFile || src.Line != 17 {
+		t.Errorf("wrong source %s", src)
+	}
+
+	conf = writeTestFile(t, dir, "bad.toml", "port = 'abc'\n")
+	p = mustNewParser(t, &opts{})
+	err = p.ParseFile(conf)
+	if err == nil || !strings.Contains(err.Error(), "bad.toml:1: port: ") {
+		t.Errorf("expecting error of line 1, got %v", err)
+	}
+	conf = writeTestFile(t, dir, "array.toml", "region = ['a', 'b']\n")
+	if err := p.ParseFile(conf); err == nil {
+		t.Errorf("expecting error for array of single value")
+	}
+}
+
+func TestTOMLValueTypes(t *testing.T) {
+	type opts struct {
+		Region  string
+		Port    int
+		Debug   bool
+		Ratio   float64
+		Ports   []int
+		Started string
+	}
+	for _, c := range []struct {
+		doc  string
+		want string
+	}{
+		{"region = 5\nratio = 2\nports = [80, 443]\nstarted = 1979-05-27", ""},
+		{"port = '8080'", ""},
+		{"port = true", "expect a int value, got boolean true"},
+		{"port = 1.5", "expect a int value, got float 1.5"},
+		{"debug = 1", "expect a bool value, got integer 1"},
+		{"ports = [80, 4.5]", "expect a int value, got float 4.5"},
+		{"[[servers]]\nname = 'a'", "array of tables servers is not supported"},
+		{"[m]\nport = 1\n[m]\nregion = 'a'", "has already been defined"},
+	} {
+		p := mustNewParser(t, &opts{})
+		err := p.ParseReader(strings.NewReader(c.doc), ConfigFormatTOML)
+		if len(c.want) == 0 {
+			if err != nil {
+				t.Errorf("%q: %v", c.doc, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: want error %q, got %v", c.doc, c.want, err)
+		}
+	}
+}
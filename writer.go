@@ -0,0 +1,250 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WriteConfigOptions controls the output of WriteConfigWithOptions
+type WriteConfigOptions struct {
+	// NonDefaultOnly omits the arguments whose values are the defaults
+	NonDefaultOnly bool
+	// CommentDefaults writes the arguments whose values are the defaults
+	// commented out, e.g. to generate sample configs.  Not applicable to
+	// JSON, the arguments are omitted instead.
+	CommentDefaults bool
+	// Help writes the help text of the arguments as comments.  Not
+	// applicable to JSON.
+	Help bool
+	// ShowSecrets writes the values of secret arguments instead of
+	// RedactedValue, e.g. to save a config to be read back
+	ShowSecrets bool
+}
+
+// isDefaultValue returns whether the value is the default, or the initial
+// value of the field if there is no default
+func (this *SingleArgument) isDefaultValue() bool {
+	ref := this.ovalue
+	if this.useDefault {
+		ref = this.defValue
+	}
+	if !ref.IsValid() || !this.value.CanInterface() {
+		return false
+	}
+	return reflect.DeepEqual(this.value.Interface(), ref.Interface())
+}
+
+type configWriterArg interface {
+	Argument
+	getValue() reflect.Value
+	isDefaultValue() bool
+	IsSecret() bool
+}
+
+// WriteConfig writes the optional arguments of the parser in the format,
+// with the keys of ParseFile, so that the output can be parsed back.  The
+// values of secret arguments are written as RedactedValue.
+func (this *ArgumentParser) WriteConfig(w io.Writer, format ConfigFormat) error {
+	return this.WriteConfigWithOptions(w, format, WriteConfigOptions{})
+}
+
+func (this *ArgumentParser) WriteConfigWithOptions(w io.Writer, format ConfigFormat, opts WriteConfigOptions) error {
+	switch format {
+	case ConfigFormatYAML, ConfigFormatJSON, ConfigFormatTOML, ConfigFormatTornado:
+	default:
+		return fmt.Errorf("unsupported config format %q", format)
+	}
+	bw := bufio.NewWriter(w)
+	if format == ConfigFormatJSON {
+		bw.WriteString("{")
+	}
+	count := 0
+	for _, a := range this.optArgs {
+		arg, ok := a.(configWriterArg)
+		if !ok || arg.IsSubcommand() {
+			continue
+		}
+		isDefault := arg.isDefaultValue()
+		commented := false
+		if isDefault {
+			if opts.CommentDefaults && format != ConfigFormatJSON {
+				commented = true
+			} else if opts.NonDefaultOnly || opts.CommentDefaults {
+				continue
+			}
+		}
+		value := arg.getValue()
+		for value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				if !commented {
+					break
+				}
+				value = reflect.New(value.Type().Elem())
+			}
+			value = value.Elem()
+		}
+		if value.Kind() == reflect.Ptr {
+			// nil pointer, no value to write
+			continue
+		}
+		redact := !opts.ShowSecrets && arg.IsSecret()
+		key := tokenToKey(arg.Token())
+		if format == ConfigFormatJSON {
+			if count > 0 {
+				bw.WriteString(",")
+			}
+			fmt.Fprintf(bw, "\n  %s: %s", jsonQuote(key), formatConfigValue(format, value, redact))
+			count++
+			continue
+		}
+		if opts.Help {
+			if help := arg.HelpString(""); len(help) > 0 {
+				if count > 0 {
+					bw.WriteString("\n")
+				}
+				for _, line := range strings.Split(help, "\n") {
+					fmt.Fprintf(bw, "# %s\n", line)
+				}
+			}
+		}
+		if commented {
+			bw.WriteString("# ")
+		}
+		sep := " = "
+		if format == ConfigFormatYAML {
+			sep = ": "
+		}
+		fmt.Fprintf(bw, "%s%s%s\n", key, sep, formatConfigValue(format, value, redact))
+		count++
+	}
+	if format == ConfigFormatJSON {
+		if count > 0 {
+			bw.WriteString("\n")
+		}
+		bw.WriteString("}\n")
+	}
+	return bw.Flush()
+}
+
+// tokenToKey is the inverse of keyToToken
+func tokenToKey(token string) string {
+	return strings.Replace(token, "-", "_", -1)
+}
+
+func jsonQuote(str string) string {
+	data, _ := json.Marshal(str)
+	return string(data)
+}
+
+// formatConfigValue formats a value of an argument, arrays and maps in the
+// inline syntax of the format
+func formatConfigValue(format ConfigFormat, value reflect.Value, redact bool) string {
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		items := make([]string, value.Len())
+		for i := range items {
+			items[i] = formatConfigScalar(format, value.Index(i), redact)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			kstr := fmt.Sprint(k.Interface())
+			v := value.MapIndex(k)
+			switch format {
+			case ConfigFormatTornado:
+				// tornado style maps are arrays of key=value
+				vstr := fmt.Sprint(v.Interface())
+				if redact {
+					vstr = RedactedValue
+				}
+				items[i] = tornadoQuote(kstr + "=" + vstr)
+			case ConfigFormatTOML:
+				items[i] = jsonQuote(kstr) + " = " + formatConfigScalar(format, v, redact)
+			default:
+				items[i] = jsonQuote(kstr) + ": " + formatConfigScalar(format, v, redact)
+			}
+		}
+		if format == ConfigFormatTornado {
+			return "[" + strings.Join(items, ", ") + "]"
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	}
+	return formatConfigScalar(format, value, redact)
+}
+
+func formatConfigScalar(format ConfigFormat, value reflect.Value, redact bool) string {
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return formatConfigString(format, "")
+		}
+		value = value.Elem()
+	}
+	if redact {
+		return formatConfigString(format, RedactedValue)
+	}
+	switch value.Kind() {
+	case reflect.Bool:
+		if format == ConfigFormatTornado {
+			// python style as in tornado config files
+			if value.Bool() {
+				return "True"
+			}
+			return "False"
+		}
+		return strconv.FormatBool(value.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(value.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		str := strconv.FormatFloat(value.Float(), 'g', -1, 64)
+		if format == ConfigFormatTOML && !strings.ContainsAny(str, ".eEnN") {
+			// TOML floats need a fractional part
+			str += ".0"
+		}
+		return str
+	case reflect.String:
+		return formatConfigString(format, value.String())
+	}
+	return formatConfigString(format, fmt.Sprint(value.Interface()))
+}
+
+func formatConfigString(format ConfigFormat, str string) string {
+	if format == ConfigFormatTornado {
+		return tornadoQuote(str)
+	}
+	// a JSON string is also a YAML double-quoted string and a TOML basic
+	// string
+	return jsonQuote(str)
+}
+
+var tornadoEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func tornadoQuote(str string) string {
+	return `'` + tornadoEscaper.Replace(str) + `'`
+}
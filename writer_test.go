@@ -0,0 +1,140 @@
+// Copyright 2019 Yunion
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package structarg
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type writerTestOptions struct {
+	Region        string   `help:"Region name"`
+	Port          int      `default:"8080" help:"Listen port"`
+	Ratio         float64  `default:"1"`
+	Debug         bool     `help:"Show debug information\nin the log"`
+	Insecure      *bool    `default:"false"`
+	Quoted        string   `default:"it's \"quoted\""`
+	AdminPassword string   `secret:"true"`
+	DNSResolvers  []string `default:"8.8.8.8"`
+	Ports         []int
+	Labels        map[string]string
+	M             struct {
+		NonPos string
+	}
+}
+
+func TestWriteConfigRoundTrip(t *testing.T) {
+	args := []string{
+		"--region", "region#0",
+		"--port", "9090",
+		"--ratio", "0.5",
+		"--debug",
+		"--admin-password", "p@ss'word#1",
+		"--dns-resolvers", "1.1.1.1", "--dns-resolvers", "9.9.9.9",
+		"--ports", "80", "--ports", "443",
+		"--labels", "a.b=1", "--labels", "c=x y",
+		"--m-non-pos", "x",
+	}
+	for _, format := range []ConfigFormat{ConfigFormatYAML, ConfigFormatJSON, ConfigFormatTOML, ConfigFormatTornado} {
+		t.Run(string(format), func(t *testing.T) {
+			s := &writerTestOptions{}
+			p := mustNewParser(t, s)
+			if err := p.ParseArgs(args, false); err != nil {
+				t.Fatalf("ParseArgs: %v", err)
+			}
+			buf := &bytes.Buffer{}
+			if err := p.WriteConfigWithOptions(buf, format, WriteConfigOptions{ShowSecrets: true}); err != nil {
+				t.Fatalf("WriteConfig: %v", err)
+			}
+			s2 := &writerTestOptions{}
+			p2 := mustNewParser(t, s2)
+			p2.SetStrictConfig(true)
+			if err := p2.ParseReader(bytes.NewReader(buf.Bytes()), format); err != nil {
+				t.Fatalf("ParseReader: %v\n%s", err, buf.String())
+			}
+			p2.SetDefault()
+			if !reflect.DeepEqual(s, s2) {
+				t.Errorf("round trip mismatch\nwant %#v\ngot  %#v\n%s", s, s2, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteConfigOptions(t *testing.T) {
+	s := &writerTestOptions{}
+	p := mustNewParser(t, s)
+	if err := p.ParseArgs([]string{"--port", "9090", "--admin-password", "secret"}, false); err != nil {
+		t.Fatalf("ParseArgs: %v", err)
+	}
+	t.Run("non default only", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := p.WriteConfigWithOptions(buf, ConfigFormatTornado, WriteConfigOptions{NonDefaultOnly: true})
+		if err != nil {
+			t.Fatalf("WriteConfigWithOptions: %v", err)
+		}
+		want := "port = 9090\nadmin_password = '******'\n"
+		if buf.String() != want {
+			t.Errorf("want\n%s\ngot\n%s", want, buf.String())
+		}
+	})
+	t.Run("sample", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := p.WriteConfigWithOptions(buf, ConfigFormatYAML, WriteConfigOptions{CommentDefaults: true, Help: true})
+		if err != nil {
+			t.Fatalf("WriteConfigWithOptions: %v", err)
+		}
+		out := buf.String()
+		for _, want := range []string{
+			"# Region name\n# region: \"\"\n",
+			"# Listen port\nport: 9090\n",
+			"# Show debug information\n# in the log\n# debug: false\n",
+			"# insecure: false\n",
+			"admin_password: \"******\"\n",
+			"# dns_resolvers: [\"8.8.8.8\"]\n",
+			"# labels: {}\n",
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("%q not found in\n%s", want, out)
+			}
+		}
+		// commented defaults are parsed back as unset
+		s2 := &writerTestOptions{}
+		p2 := mustNewParser(t, s2)
+		if err := p2.ParseYAML(buf.Bytes()); err != nil {
+			t.Fatalf("ParseYAML: %v", err)
+		}
+		if s2.Port != 9090 || s2.Region != "" || s2.AdminPassword != RedactedValue {
+			t.Errorf("wrong parse result: %#v", s2)
+		}
+	})
+	t.Run("json", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := p.WriteConfigWithOptions(buf, ConfigFormatJSON, WriteConfigOptions{CommentDefaults: true, ShowSecrets: true})
+		if err != nil {
+			t.Fatalf("WriteConfigWithOptions: %v", err)
+		}
+		want := "{\n  \"port\": 9090,\n  \"admin_password\": \"secret\"\n}\n"
+		if buf.String() != want {
+			t.Errorf("want\n%s\ngot\n%s", want, buf.String())
+		}
+	})
+	t.Run("unsupported", func(t *testing.T) {
+		if err := p.WriteConfig(&bytes.Buffer{}, ConfigFormat("ini")); err == nil {
+			t.Errorf("expecting error for unsupported format")
+		}
+	})
+}